@@ -0,0 +1,69 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Example structures for serializergen, used to check that the generated
+// code agrees with the reflective codecs of the serializer package.
+package example
+
+import "time"
+
+//go:generate go run ../..
+
+type Kind uint16
+
+type Tags []string
+
+//serializer:generate
+type Point struct{
+	X,Y float64
+	Label string
+}
+
+//serializer:generate
+type Message struct{
+	ID       uint64            `serializer:"1"`
+	Kind     Kind              `serializer:"2"`
+	Flags    int8              `serializer:"3"`
+	Mask     byte              `serializer:"4"`
+	Valid    bool              `serializer:"5"`
+	Delta    int32             `serializer:"6"`
+	Ratio    float32           `serializer:"7"`
+	Phase    complex128        `serializer:"8"`
+	Small    complex64         `serializer:"9"`
+	Body     []byte            `serializer:"10"`
+	Tags     Tags              `serializer:"11"`
+	Sent     time.Time         `serializer:"12"`
+	Origin   Point             `serializer:"13"`
+	Path     []Point           `serializer:"14"`
+	Next     *Message          `serializer:"15"`
+	Index    map[string]int    `serializer:"16"`
+	Places   map[int32]Point   `serializer:"17"`
+	Grid     [2][3]int         `serializer:"18"`
+	Optional *int              `serializer:"19"`
+	Nested   [][]string        `serializer:"20"`
+	Links    map[uint8]*Point  `serializer:"21"`
+	Weights  map[float64]Point `serializer:"22"`
+	
+	Cache    interface{} // Not tagged, thus not serialized.
+}
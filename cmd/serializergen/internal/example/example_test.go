@@ -0,0 +1,129 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package example
+
+import "github.com/byte-mug/golibs/preciseio"
+import "github.com/byte-mug/golibs/serializer"
+import "bytes"
+import "encoding/binary"
+import "math"
+import "reflect"
+import "testing"
+import "time"
+
+func sample() *Message {
+	one := 1
+	return &Message{
+		ID:1<<40,Kind:7,Flags:-3,Mask:0xaa,Valid:true,Delta:-123456,
+		Ratio:0.25,Phase:complex(1.5,-2),Small:complex(3,4),
+		Body:[]byte("body"),Tags:Tags{"a","","c"},
+		Sent:time.Unix(1600000000,123456789).UTC(),
+		Origin:Point{1,2,"origin"},
+		Path:[]Point{{3,4,"p"},{5,6,""}},
+		Next:&Message{ID:2,Index:map[string]int{}},
+		Index:map[string]int{"zeta":26,"alpha":1,"mu":12,"":0},
+		Places:map[int32]Point{-1:{1,1,"m"},300:{2,2,"n"},0:{}},
+		Grid:[2][3]int{{1,2,3},{-4,-5,-6}},
+		Optional:&one,
+		Nested:[][]string{{"x"},nil,{"y","z"}},
+		Links:map[uint8]*Point{9:nil,1:{7,8,"l"}},
+		Weights:map[float64]Point{-0.5:{1,2,"w"},1e9:{}},
+		Cache:"ignored",
+	}
+}
+
+func encode(t *testing.T, write func(w *preciseio.PreciseWriter) error) []byte {
+	var buf bytes.Buffer
+	w := &preciseio.PreciseWriter{W:&buf}
+	w.Initialize()
+	if e := write(w); e!=nil { t.Fatal(e) }
+	return buf.Bytes()
+}
+
+func reader(data []byte) preciseio.PreciseReader {
+	return preciseio.PreciseReader{R:bytes.NewReader(data)}
+}
+
+func TestGeneratedMatchesReflective(t *testing.T) {
+	ce := serializer.ForStructInline(new(Message))
+	for _,m := range []*Message{sample(),new(Message),{Next:sample()}} {
+		gen := encode(t,m.Write)
+		ref := encode(t,func(w *preciseio.PreciseWriter) error { return ce.Write(w,reflect.ValueOf(m).Elem()) })
+		if !bytes.Equal(gen,ref) {
+			t.Fatalf("encodings differ:\ngenerated:  %x\nreflective: %x",gen,ref)
+		}
+		
+		var a,b Message
+		if e := a.Read(reader(gen)); e!=nil { t.Fatal(e) }
+		if e := ce.Read(reader(gen),reflect.ValueOf(&b).Elem()); e!=nil { t.Fatal(e) }
+		if !reflect.DeepEqual(&a,&b) {
+			t.Fatalf("decodings differ:\ngenerated:  %+v\nreflective: %+v",a,b)
+		}
+		if again := encode(t,a.Write); !bytes.Equal(again,gen) {
+			t.Fatalf("round trip changed the encoding:\nbefore: %x\nafter:  %x",gen,again)
+		}
+	}
+}
+
+func TestGeneratedNaNKeys(t *testing.T) {
+	ce := serializer.ForStructInline(new(Message))
+	m := &Message{Weights:map[float64]Point{math.NaN():{1,2,"nan"},1:{3,4,"one"}}}
+	gen := encode(t,m.Write)
+	ref := encode(t,func(w *preciseio.PreciseWriter) error { return ce.Write(w,reflect.ValueOf(m).Elem()) })
+	if !bytes.Equal(gen,ref) { t.Fatalf("encodings differ:\ngenerated:  %x\nreflective: %x",gen,ref) }
+	var a Message
+	if e := a.Read(reader(gen)); e!=nil { t.Fatal(e) }
+	for k,v := range a.Weights {
+		if k!=k && v.Label!="nan" || k==1 && v.Label!="one" { t.Errorf("wrong entry %v: %+v",k,v) }
+	}
+}
+
+func TestGeneratedRejectsLikeReflective(t *testing.T) {
+	ce := serializer.ForStructInline(new(Message))
+	
+	// Patch the nanoseconds of Sent from 999999999 to 1e9, which has an encoding of the same length.
+	var valid,invalid [binary.MaxVarintLen64]byte
+	vn := binary.PutUvarint(valid[:],999999999)
+	in := binary.PutUvarint(invalid[:],1e9)
+	if vn!=in { t.Fatal("the encodings differ in length") }
+	data := encode(t,(&Message{Sent:time.Unix(5,999999999)}).Write)
+	i := bytes.Index(data,valid[:vn])
+	if i<0 { t.Fatal("nanoseconds not found") }
+	copy(data[i:],invalid[:in])
+	
+	var a,b Message
+	if e := a.Read(reader(data)); e!=serializer.EInvalidTime { t.Errorf("generated: got %v, want EInvalidTime",e) }
+	if e := ce.Read(reader(data),reflect.ValueOf(&b).Elem()); e!=serializer.EInvalidTime { t.Errorf("reflective: got %v, want EInvalidTime",e) }
+}
+
+func TestGeneratedTruncated(t *testing.T) {
+	data := encode(t,sample().Write)
+	for i := 0 ; i<len(data) ; i++ {
+		var m Message
+		if e := m.Read(reader(data[:i])); e==nil {
+			t.Fatalf("no error on input truncated to %d of %d bytes",i,len(data))
+		}
+	}
+}
@@ -0,0 +1,644 @@
+// Code generated by serializergen. DO NOT EDIT.
+
+package example
+
+import "bytes"
+import "github.com/byte-mug/golibs/preciseio"
+import "github.com/byte-mug/golibs/serializer"
+import "math"
+import "sort"
+import "time"
+
+// Write serializes x like serializer.ForStructInline(new(Message)).
+func (x *Message) Write(w *preciseio.PreciseWriter) error {
+	if e := w.WriteUvarint(uint64(x.ID)); e != nil {
+		return e
+	}
+	if e := w.WriteUvarint(uint64(x.Kind)); e != nil {
+		return e
+	}
+	if e := w.W.WriteByte(byte(int8(x.Flags))); e != nil {
+		return e
+	}
+	if e := w.W.WriteByte(byte(x.Mask)); e != nil {
+		return e
+	}
+	b1 := byte(0)
+	if x.Valid {
+		b1 = 0xff
+	}
+	if e := w.W.WriteByte(b1); e != nil {
+		return e
+	}
+	if e := w.WriteVarint(int64(x.Delta)); e != nil {
+		return e
+	}
+	if e := w.WriteUint32(math.Float32bits(float32(x.Ratio))); e != nil {
+		return e
+	}
+	if e := w.WriteUint64(math.Float64bits(real(x.Phase))); e != nil {
+		return e
+	}
+	if e := w.WriteUint64(math.Float64bits(imag(x.Phase))); e != nil {
+		return e
+	}
+	if e := w.WriteUint32(math.Float32bits(real(x.Small))); e != nil {
+		return e
+	}
+	if e := w.WriteUint32(math.Float32bits(imag(x.Small))); e != nil {
+		return e
+	}
+	if e := w.WriteBlob([]byte(x.Body)); e != nil {
+		return e
+	}
+	if e := w.WriteListLength(len(x.Tags)); e != nil {
+		return e
+	}
+	for i2 := range x.Tags {
+		if e := w.WriteBlob([]byte(x.Tags[i2])); e != nil {
+			return e
+		}
+	}
+	if e := w.WriteVarint(x.Sent.Unix()); e != nil {
+		return e
+	}
+	if e := w.WriteUvarint(uint64(x.Sent.Nanosecond())); e != nil {
+		return e
+	}
+	if e := x.Origin.Write(w); e != nil {
+		return e
+	}
+	if e := w.WriteListLength(len(x.Path)); e != nil {
+		return e
+	}
+	for i3 := range x.Path {
+		if e := x.Path[i3].Write(w); e != nil {
+			return e
+		}
+	}
+	if x.Next == nil {
+		if e := w.W.WriteByte(0); e != nil {
+			return e
+		}
+	} else {
+		if e := w.W.WriteByte(0xff); e != nil {
+			return e
+		}
+		if e := (*x.Next).Write(w); e != nil {
+			return e
+		}
+	}
+	if x.Index == nil {
+		if e := w.W.WriteByte(0); e != nil {
+			return e
+		}
+	} else {
+		if e := w.W.WriteByte(0xff); e != nil {
+			return e
+		}
+		if e := w.WriteListLength(len(x.Index)); e != nil {
+			return e
+		}
+		// Collect keys and values together: NaN keys can't be looked up.
+		keys4 := make([]string, 0, len(x.Index))
+		vals5 := make([]int, 0, len(x.Index))
+		for k11, v12 := range x.Index {
+			keys4 = append(keys4, k11)
+			vals5 = append(vals5, v12)
+		}
+		// Sort the entries by their encoded keys.
+		buf6 := new(bytes.Buffer)
+		kw7 := preciseio.PreciseWriterFromPool()
+		kw7.W = buf6
+		offs8 := make([]int, len(keys4)+1)
+		for i10 := range keys4 {
+			if e := kw7.WriteBlob([]byte(keys4[i10])); e != nil {
+				return e
+			}
+			offs8[i10+1] = buf6.Len()
+		}
+		kw7.PutToPool()
+		idx9 := make([]int, len(keys4))
+		for i10 := range idx9 {
+			idx9[i10] = i10
+		}
+		sort.Slice(idx9, func(i, j int) bool {
+			a, b := idx9[i], idx9[j]
+			return bytes.Compare(buf6.Bytes()[offs8[a]:offs8[a+1]], buf6.Bytes()[offs8[b]:offs8[b+1]]) < 0
+		})
+		for _, i10 := range idx9 {
+			if _, e := w.W.Write(buf6.Bytes()[offs8[i10]:offs8[i10+1]]); e != nil {
+				return e
+			}
+			if e := w.WriteVarint(int64(vals5[i10])); e != nil {
+				return e
+			}
+		}
+	}
+	if x.Places == nil {
+		if e := w.W.WriteByte(0); e != nil {
+			return e
+		}
+	} else {
+		if e := w.W.WriteByte(0xff); e != nil {
+			return e
+		}
+		if e := w.WriteListLength(len(x.Places)); e != nil {
+			return e
+		}
+		// Collect keys and values together: NaN keys can't be looked up.
+		keys13 := make([]int32, 0, len(x.Places))
+		vals14 := make([]Point, 0, len(x.Places))
+		for k20, v21 := range x.Places {
+			keys13 = append(keys13, k20)
+			vals14 = append(vals14, v21)
+		}
+		// Sort the entries by their encoded keys.
+		buf15 := new(bytes.Buffer)
+		kw16 := preciseio.PreciseWriterFromPool()
+		kw16.W = buf15
+		offs17 := make([]int, len(keys13)+1)
+		for i19 := range keys13 {
+			if e := kw16.WriteVarint(int64(keys13[i19])); e != nil {
+				return e
+			}
+			offs17[i19+1] = buf15.Len()
+		}
+		kw16.PutToPool()
+		idx18 := make([]int, len(keys13))
+		for i19 := range idx18 {
+			idx18[i19] = i19
+		}
+		sort.Slice(idx18, func(i, j int) bool {
+			a, b := idx18[i], idx18[j]
+			return bytes.Compare(buf15.Bytes()[offs17[a]:offs17[a+1]], buf15.Bytes()[offs17[b]:offs17[b+1]]) < 0
+		})
+		for _, i19 := range idx18 {
+			if _, e := w.W.Write(buf15.Bytes()[offs17[i19]:offs17[i19+1]]); e != nil {
+				return e
+			}
+			if e := vals14[i19].Write(w); e != nil {
+				return e
+			}
+		}
+	}
+	for i22 := range x.Grid {
+		for i23 := range x.Grid[i22] {
+			if e := w.WriteVarint(int64(x.Grid[i22][i23])); e != nil {
+				return e
+			}
+		}
+	}
+	if x.Optional == nil {
+		if e := w.W.WriteByte(0); e != nil {
+			return e
+		}
+	} else {
+		if e := w.W.WriteByte(0xff); e != nil {
+			return e
+		}
+		if e := w.WriteVarint(int64((*x.Optional))); e != nil {
+			return e
+		}
+	}
+	if e := w.WriteListLength(len(x.Nested)); e != nil {
+		return e
+	}
+	for i24 := range x.Nested {
+		if e := w.WriteListLength(len(x.Nested[i24])); e != nil {
+			return e
+		}
+		for i25 := range x.Nested[i24] {
+			if e := w.WriteBlob([]byte(x.Nested[i24][i25])); e != nil {
+				return e
+			}
+		}
+	}
+	if x.Links == nil {
+		if e := w.W.WriteByte(0); e != nil {
+			return e
+		}
+	} else {
+		if e := w.W.WriteByte(0xff); e != nil {
+			return e
+		}
+		if e := w.WriteListLength(len(x.Links)); e != nil {
+			return e
+		}
+		// Collect keys and values together: NaN keys can't be looked up.
+		keys26 := make([]uint8, 0, len(x.Links))
+		vals27 := make([]*Point, 0, len(x.Links))
+		for k33, v34 := range x.Links {
+			keys26 = append(keys26, k33)
+			vals27 = append(vals27, v34)
+		}
+		// Sort the entries by their encoded keys.
+		buf28 := new(bytes.Buffer)
+		kw29 := preciseio.PreciseWriterFromPool()
+		kw29.W = buf28
+		offs30 := make([]int, len(keys26)+1)
+		for i32 := range keys26 {
+			if e := kw29.W.WriteByte(byte(keys26[i32])); e != nil {
+				return e
+			}
+			offs30[i32+1] = buf28.Len()
+		}
+		kw29.PutToPool()
+		idx31 := make([]int, len(keys26))
+		for i32 := range idx31 {
+			idx31[i32] = i32
+		}
+		sort.Slice(idx31, func(i, j int) bool {
+			a, b := idx31[i], idx31[j]
+			return bytes.Compare(buf28.Bytes()[offs30[a]:offs30[a+1]], buf28.Bytes()[offs30[b]:offs30[b+1]]) < 0
+		})
+		for _, i32 := range idx31 {
+			if _, e := w.W.Write(buf28.Bytes()[offs30[i32]:offs30[i32+1]]); e != nil {
+				return e
+			}
+			if vals27[i32] == nil {
+				if e := w.W.WriteByte(0); e != nil {
+					return e
+				}
+			} else {
+				if e := w.W.WriteByte(0xff); e != nil {
+					return e
+				}
+				if e := (*vals27[i32]).Write(w); e != nil {
+					return e
+				}
+			}
+		}
+	}
+	if x.Weights == nil {
+		if e := w.W.WriteByte(0); e != nil {
+			return e
+		}
+	} else {
+		if e := w.W.WriteByte(0xff); e != nil {
+			return e
+		}
+		if e := w.WriteListLength(len(x.Weights)); e != nil {
+			return e
+		}
+		// Collect keys and values together: NaN keys can't be looked up.
+		keys35 := make([]float64, 0, len(x.Weights))
+		vals36 := make([]Point, 0, len(x.Weights))
+		for k42, v43 := range x.Weights {
+			keys35 = append(keys35, k42)
+			vals36 = append(vals36, v43)
+		}
+		// Sort the entries by their encoded keys.
+		buf37 := new(bytes.Buffer)
+		kw38 := preciseio.PreciseWriterFromPool()
+		kw38.W = buf37
+		offs39 := make([]int, len(keys35)+1)
+		for i41 := range keys35 {
+			if e := kw38.WriteUint64(math.Float64bits(float64(keys35[i41]))); e != nil {
+				return e
+			}
+			offs39[i41+1] = buf37.Len()
+		}
+		kw38.PutToPool()
+		idx40 := make([]int, len(keys35))
+		for i41 := range idx40 {
+			idx40[i41] = i41
+		}
+		sort.Slice(idx40, func(i, j int) bool {
+			a, b := idx40[i], idx40[j]
+			return bytes.Compare(buf37.Bytes()[offs39[a]:offs39[a+1]], buf37.Bytes()[offs39[b]:offs39[b+1]]) < 0
+		})
+		for _, i41 := range idx40 {
+			if _, e := w.W.Write(buf37.Bytes()[offs39[i41]:offs39[i41+1]]); e != nil {
+				return e
+			}
+			if e := vals36[i41].Write(w); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// Read deserializes x like serializer.ForStructInline(new(Message)).
+func (x *Message) Read(r preciseio.PreciseReader) error {
+	v44, e := r.ReadUvarint()
+	if e != nil {
+		return e
+	}
+	x.ID = uint64(v44)
+	v45, e := r.ReadUvarint()
+	if e != nil {
+		return e
+	}
+	x.Kind = Kind(v45)
+	v46, e := r.R.ReadByte()
+	if e != nil {
+		return e
+	}
+	x.Flags = int8(int8(v46))
+	v47, e := r.R.ReadByte()
+	if e != nil {
+		return e
+	}
+	x.Mask = byte(v47)
+	v48, e := r.R.ReadByte()
+	if e != nil {
+		return e
+	}
+	x.Valid = bool(v48 != 0)
+	v49, e := r.ReadVarint()
+	if e != nil {
+		return e
+	}
+	x.Delta = int32(v49)
+	v50, e := r.ReadUint32()
+	if e != nil {
+		return e
+	}
+	x.Ratio = float32(math.Float32frombits(v50))
+	v51, e := r.ReadUint64()
+	if e != nil {
+		return e
+	}
+	i52, e := r.ReadUint64()
+	if e != nil {
+		return e
+	}
+	x.Phase = complex128(complex(math.Float64frombits(v51), math.Float64frombits(i52)))
+	v53, e := r.ReadUint32()
+	if e != nil {
+		return e
+	}
+	i54, e := r.ReadUint32()
+	if e != nil {
+		return e
+	}
+	x.Small = complex64(complex(math.Float32frombits(v53), math.Float32frombits(i54)))
+	v55, e := r.ReadBlob()
+	if e != nil {
+		return e
+	}
+	x.Body = []byte(v55)
+	n56, e := r.ReadListLength()
+	if e != nil {
+		return e
+	}
+	if n56 == 0 {
+		x.Tags = nil
+	} else {
+		x.Tags = make(Tags, n56)
+		for i57 := range x.Tags {
+			v58, e := r.ReadBlob()
+			if e != nil {
+				return e
+			}
+			x.Tags[i57] = string(v58)
+		}
+	}
+	s59, e := r.ReadVarint()
+	if e != nil {
+		return e
+	}
+	ns60, e := r.ReadUvarint()
+	if e != nil {
+		return e
+	}
+	if ns60 >= 1e9 {
+		return serializer.EInvalidTime
+	}
+	x.Sent = time.Unix(s59, int64(ns60)).UTC()
+	if e := x.Origin.Read(r); e != nil {
+		return e
+	}
+	n61, e := r.ReadListLength()
+	if e != nil {
+		return e
+	}
+	if n61 == 0 {
+		x.Path = nil
+	} else {
+		x.Path = make([]Point, n61)
+		for i62 := range x.Path {
+			if e := x.Path[i62].Read(r); e != nil {
+				return e
+			}
+		}
+	}
+	b63, e := r.R.ReadByte()
+	if e != nil {
+		return e
+	}
+	if b63 == 0 {
+		x.Next = nil
+	} else {
+		x.Next = new(Message)
+		if e := (*x.Next).Read(r); e != nil {
+			return e
+		}
+	}
+	b64, e := r.R.ReadByte()
+	if e != nil {
+		return e
+	}
+	if b64 == 0 {
+		x.Index = nil
+	} else {
+		n65, e := r.ReadListLength()
+		if e != nil {
+			return e
+		}
+		m66 := make(map[string]int, n65)
+		for i67 := 0; i67 < n65; i67++ {
+			var k68 string
+			var v69 int
+			v70, e := r.ReadBlob()
+			if e != nil {
+				return e
+			}
+			k68 = string(v70)
+			v71, e := r.ReadVarint()
+			if e != nil {
+				return e
+			}
+			v69 = int(v71)
+			m66[k68] = v69
+		}
+		x.Index = m66
+	}
+	b72, e := r.R.ReadByte()
+	if e != nil {
+		return e
+	}
+	if b72 == 0 {
+		x.Places = nil
+	} else {
+		n73, e := r.ReadListLength()
+		if e != nil {
+			return e
+		}
+		m74 := make(map[int32]Point, n73)
+		for i75 := 0; i75 < n73; i75++ {
+			var k76 int32
+			var v77 Point
+			v78, e := r.ReadVarint()
+			if e != nil {
+				return e
+			}
+			k76 = int32(v78)
+			if e := v77.Read(r); e != nil {
+				return e
+			}
+			m74[k76] = v77
+		}
+		x.Places = m74
+	}
+	for i79 := range x.Grid {
+		for i80 := range x.Grid[i79] {
+			v81, e := r.ReadVarint()
+			if e != nil {
+				return e
+			}
+			x.Grid[i79][i80] = int(v81)
+		}
+	}
+	b82, e := r.R.ReadByte()
+	if e != nil {
+		return e
+	}
+	if b82 == 0 {
+		x.Optional = nil
+	} else {
+		x.Optional = new(int)
+		v83, e := r.ReadVarint()
+		if e != nil {
+			return e
+		}
+		(*x.Optional) = int(v83)
+	}
+	n84, e := r.ReadListLength()
+	if e != nil {
+		return e
+	}
+	if n84 == 0 {
+		x.Nested = nil
+	} else {
+		x.Nested = make([][]string, n84)
+		for i85 := range x.Nested {
+			n86, e := r.ReadListLength()
+			if e != nil {
+				return e
+			}
+			if n86 == 0 {
+				x.Nested[i85] = nil
+			} else {
+				x.Nested[i85] = make([]string, n86)
+				for i87 := range x.Nested[i85] {
+					v88, e := r.ReadBlob()
+					if e != nil {
+						return e
+					}
+					x.Nested[i85][i87] = string(v88)
+				}
+			}
+		}
+	}
+	b89, e := r.R.ReadByte()
+	if e != nil {
+		return e
+	}
+	if b89 == 0 {
+		x.Links = nil
+	} else {
+		n90, e := r.ReadListLength()
+		if e != nil {
+			return e
+		}
+		m91 := make(map[uint8]*Point, n90)
+		for i92 := 0; i92 < n90; i92++ {
+			var k93 uint8
+			var v94 *Point
+			v95, e := r.R.ReadByte()
+			if e != nil {
+				return e
+			}
+			k93 = uint8(v95)
+			b96, e := r.R.ReadByte()
+			if e != nil {
+				return e
+			}
+			if b96 == 0 {
+				v94 = nil
+			} else {
+				v94 = new(Point)
+				if e := (*v94).Read(r); e != nil {
+					return e
+				}
+			}
+			m91[k93] = v94
+		}
+		x.Links = m91
+	}
+	b97, e := r.R.ReadByte()
+	if e != nil {
+		return e
+	}
+	if b97 == 0 {
+		x.Weights = nil
+	} else {
+		n98, e := r.ReadListLength()
+		if e != nil {
+			return e
+		}
+		m99 := make(map[float64]Point, n98)
+		for i100 := 0; i100 < n98; i100++ {
+			var k101 float64
+			var v102 Point
+			v103, e := r.ReadUint64()
+			if e != nil {
+				return e
+			}
+			k101 = float64(math.Float64frombits(v103))
+			if e := v102.Read(r); e != nil {
+				return e
+			}
+			m99[k101] = v102
+		}
+		x.Weights = m99
+	}
+	return nil
+}
+
+// Write serializes x like serializer.ForStructInline(new(Point)).
+func (x *Point) Write(w *preciseio.PreciseWriter) error {
+	if e := w.WriteUint64(math.Float64bits(float64(x.X))); e != nil {
+		return e
+	}
+	if e := w.WriteUint64(math.Float64bits(float64(x.Y))); e != nil {
+		return e
+	}
+	if e := w.WriteBlob([]byte(x.Label)); e != nil {
+		return e
+	}
+	return nil
+}
+
+// Read deserializes x like serializer.ForStructInline(new(Point)).
+func (x *Point) Read(r preciseio.PreciseReader) error {
+	v104, e := r.ReadUint64()
+	if e != nil {
+		return e
+	}
+	x.X = float64(math.Float64frombits(v104))
+	v105, e := r.ReadUint64()
+	if e != nil {
+		return e
+	}
+	x.Y = float64(math.Float64frombits(v105))
+	v106, e := r.ReadBlob()
+	if e != nil {
+		return e
+	}
+	x.Label = string(v106)
+	return nil
+}
@@ -0,0 +1,519 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Serializergen generates reflection-free Read/Write methods for structures,
+producing exactly the same bytes as serializer.ForStructInline(new(T)).
+
+Structures are annotated with a //serializer:generate comment:
+
+	//go:generate serializergen
+	
+	//serializer:generate
+	type Foo struct{
+		Naming  int    `serializer:"1"`
+		Content string `serializer:"2"`
+	}
+
+The generated methods are:
+
+	func (x *Foo) Write(w *preciseio.PreciseWriter) error
+	func (x *Foo) Read(r preciseio.PreciseReader) error
+
+Supported field types are the same as for serializer.ForStruct(), except interfaces.
+Nested structures must be annotated as well. Types from other packages are not
+supported, with the exception of time.Time (which imports the serializer package
+for serializer.EInvalidTime, to reject the same input as the reflective codec).
+
+The generated Read methods do not enforce serializer.Limits, so they should only be
+used on trusted input.
+
+Usage:
+
+	serializergen [-o output] [directory]
+
+The directory defaults to the current directory, the output to serializer_gen.go.
+*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const annotation = "serializer:generate"
+
+type tkind int
+const (
+	tkBasic tkind = iota
+	tkBytes
+	tkSlice
+	tkArray
+	tkMap
+	tkPtr
+	tkStruct
+	tkTime
+)
+
+// The Go type of a field.
+type gtype struct{
+	kind  tkind
+	src   string // the type expression in Go source code
+	basic string // the underlying basic type, if tkBasic
+	alen  string // the array length, if tkArray
+	key   *gtype
+	elem  *gtype
+}
+
+type gfield struct{
+	ord  uint64
+	name string
+	expr ast.Expr
+	typ  *gtype
+}
+
+type gstruct struct{
+	name   string
+	fields []gfield
+}
+
+type generator struct{
+	pkg     string
+	types   map[string]ast.Expr // all type declarations of the package
+	structs []*gstruct
+	imports map[string]bool
+	buf     bytes.Buffer
+	tmp     int
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf,format,args...)
+}
+func (g *generator) temp(prefix string) string {
+	g.tmp++
+	return fmt.Sprintf("%s%d",prefix,g.tmp)
+}
+
+func isAnnotated(groups ...*ast.CommentGroup) bool {
+	for _,cg := range groups {
+		if cg==nil { continue }
+		for _,c := range cg.List {
+			if strings.TrimSpace(strings.TrimPrefix(c.Text,"//"))==annotation { return true }
+		}
+	}
+	return false
+}
+
+var basicTypes = map[string]string{
+	"bool":"bool", "string":"string",
+	"int":"int", "int8":"int8", "int16":"int16", "int32":"int32", "int64":"int64", "rune":"int32",
+	"uint":"uint", "uint8":"uint8", "uint16":"uint16", "uint32":"uint32", "uint64":"uint64", "byte":"uint8",
+	"float32":"float32", "float64":"float64", "complex64":"complex64", "complex128":"complex128",
+}
+
+func (g *generator) resolve(e ast.Expr, annotated map[string]bool) (*gtype,error) {
+	src := exprString(e)
+	switch t := e.(type) {
+	case *ast.Ident:
+		if b,ok := basicTypes[t.Name]; ok { return &gtype{kind:tkBasic,src:src,basic:b},nil }
+		if annotated[t.Name] { return &gtype{kind:tkStruct,src:src},nil }
+		def,ok := g.types[t.Name]
+		if !ok { return nil,fmt.Errorf("unknown type %s",src) }
+		if _,ok := def.(*ast.StructType); ok { return nil,fmt.Errorf("structure %s is not annotated with //%s",src,annotation) }
+		u,err := g.resolve(def,annotated)
+		if err!=nil { return nil,err }
+		nu := *u
+		nu.src = src
+		return &nu,nil
+	case *ast.SelectorExpr:
+		if src=="time.Time" {
+			g.imports["time"] = true
+			g.imports["github.com/byte-mug/golibs/serializer"] = true
+			return &gtype{kind:tkTime,src:src},nil
+		}
+	case *ast.StarExpr:
+		el,err := g.resolve(t.X,annotated)
+		if err!=nil { return nil,err }
+		return &gtype{kind:tkPtr,src:src,elem:el},nil
+	case *ast.ArrayType:
+		el,err := g.resolve(t.Elt,annotated)
+		if err!=nil { return nil,err }
+		if t.Len!=nil { return &gtype{kind:tkArray,src:src,alen:exprString(t.Len),elem:el},nil }
+		if el.kind==tkBasic && el.basic=="uint8" { return &gtype{kind:tkBytes,src:src,elem:el},nil }
+		return &gtype{kind:tkSlice,src:src,elem:el},nil
+	case *ast.MapType:
+		k,err := g.resolve(t.Key,annotated)
+		if err!=nil { return nil,err }
+		v,err := g.resolve(t.Value,annotated)
+		if err!=nil { return nil,err }
+		g.imports["bytes"] = true
+		g.imports["sort"] = true
+		return &gtype{kind:tkMap,src:src,key:k,elem:v},nil
+	}
+	return nil,fmt.Errorf("unsupported type %s",src)
+}
+
+func exprString(e ast.Expr) string {
+	var b bytes.Buffer
+	format.Node(&b,token.NewFileSet(),e)
+	return b.String()
+}
+
+// Mirrors the field selection of the serializer package.
+func (g *generator) fields(name string, st *ast.StructType, annotated map[string]bool) ([]gfield,error) {
+	var tagged,untagged []gfield
+	for _,f := range st.Fields.List {
+		tag := ""
+		if f.Tag!=nil {
+			s,_ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(s).Get("serializer")
+		}
+		if tag=="-" { continue }
+		if j := strings.IndexByte(tag,','); j>=0 { tag = tag[:j] }
+		names := f.Names
+		if len(names)==0 { return nil,fmt.Errorf("%s: embedded fields are not supported",name) }
+		for _,n := range names {
+			if tag=="" {
+				if n.IsExported() { untagged = append(untagged,gfield{uint64(len(untagged)+1),n.Name,f.Type,nil}) }
+				continue
+			}
+			ord,err := strconv.ParseUint(tag,10,32)
+			if err!=nil || ord==0 { return nil,fmt.Errorf("%s.%s: invalid tag %q",name,n.Name,tag) }
+			if !n.IsExported() { return nil,fmt.Errorf("%s.%s: unexported field can not be serialized",name,n.Name) }
+			tagged = append(tagged,gfield{ord,n.Name,f.Type,nil})
+		}
+	}
+	if len(tagged)==0 {
+		tagged = untagged
+	} else {
+		sort.SliceStable(tagged,func(i,j int) bool { return tagged[i].ord<tagged[j].ord })
+		for i := 1 ; i<len(tagged) ; i++ {
+			if tagged[i-1].ord==tagged[i].ord { return nil,fmt.Errorf("%s.%s: ordinal %d is already used",name,tagged[i].name,tagged[i].ord) }
+		}
+	}
+	for i := range tagged {
+		typ,err := g.resolve(tagged[i].expr,annotated)
+		if err!=nil { return nil,fmt.Errorf("%s.%s: %v",name,tagged[i].name,err) }
+		tagged[i].typ = typ
+	}
+	return tagged,nil
+}
+
+const check = "; e!=nil { return e }\n"
+
+// Emits code writing the value expr of type t to w.
+func (g *generator) write(t *gtype, expr, w string, addressable bool) {
+	switch t.kind {
+	case tkBasic:
+		switch t.basic {
+		case "bool":
+			b := g.temp("b")
+			g.printf("%s := byte(0)\nif %s { %s = 0xff }\n",b,expr,b)
+			g.printf("if e := %s.W.WriteByte(%s)"+check,w,b)
+		case "string":
+			g.printf("if e := %s.WriteBlob([]byte(%s))"+check,w,expr)
+		case "int8":
+			g.printf("if e := %s.W.WriteByte(byte(int8(%s)))"+check,w,expr)
+		case "uint8":
+			g.printf("if e := %s.W.WriteByte(byte(%s))"+check,w,expr)
+		case "int","int16","int32","int64":
+			g.printf("if e := %s.WriteVarint(int64(%s))"+check,w,expr)
+		case "uint","uint16","uint32","uint64":
+			g.printf("if e := %s.WriteUvarint(uint64(%s))"+check,w,expr)
+		case "float32":
+			g.imports["math"] = true
+			g.printf("if e := %s.WriteUint32(math.Float32bits(float32(%s)))"+check,w,expr)
+		case "float64":
+			g.imports["math"] = true
+			g.printf("if e := %s.WriteUint64(math.Float64bits(float64(%s)))"+check,w,expr)
+		case "complex64":
+			g.imports["math"] = true
+			g.printf("if e := %s.WriteUint32(math.Float32bits(real(%s)))"+check,w,expr)
+			g.printf("if e := %s.WriteUint32(math.Float32bits(imag(%s)))"+check,w,expr)
+		case "complex128":
+			g.imports["math"] = true
+			g.printf("if e := %s.WriteUint64(math.Float64bits(real(%s)))"+check,w,expr)
+			g.printf("if e := %s.WriteUint64(math.Float64bits(imag(%s)))"+check,w,expr)
+		}
+	case tkTime:
+		g.printf("if e := %s.WriteVarint(%s.Unix())"+check,w,expr)
+		g.printf("if e := %s.WriteUvarint(uint64(%s.Nanosecond()))"+check,w,expr)
+	case tkBytes:
+		g.printf("if e := %s.WriteBlob([]byte(%s))"+check,w,expr)
+	case tkStruct:
+		if !addressable {
+			v := g.temp("v")
+			g.printf("%s := %s\n",v,expr)
+			expr = v
+		}
+		g.printf("if e := %s.Write(%s)"+check,expr,w)
+	case tkPtr:
+		g.printf("if %s==nil {\n",expr)
+		g.printf("if e := %s.W.WriteByte(0)"+check,w)
+		g.printf("} else {\n")
+		g.printf("if e := %s.W.WriteByte(0xff)"+check,w)
+		g.write(t.elem,"(*"+expr+")",w,true)
+		g.printf("}\n")
+	case tkArray:
+		i := g.temp("i")
+		g.printf("for %s := range %s {\n",i,expr)
+		g.write(t.elem,expr+"["+i+"]",w,addressable)
+		g.printf("}\n")
+	case tkSlice:
+		i := g.temp("i")
+		g.printf("if e := %s.WriteListLength(len(%s))"+check,w,expr)
+		g.printf("for %s := range %s {\n",i,expr)
+		g.write(t.elem,expr+"["+i+"]",w,true)
+		g.printf("}\n")
+	case tkMap:
+		keys,vals,buf,kw,offs,idx,i,k,v := g.temp("keys"),g.temp("vals"),g.temp("buf"),g.temp("kw"),g.temp("offs"),g.temp("idx"),g.temp("i"),g.temp("k"),g.temp("v")
+		g.printf("if %s==nil {\n",expr)
+		g.printf("if e := %s.W.WriteByte(0)"+check,w)
+		g.printf("} else {\n")
+		g.printf("if e := %s.W.WriteByte(0xff)"+check,w)
+		g.printf("if e := %s.WriteListLength(len(%s))"+check,w,expr)
+		g.printf("// Collect keys and values together: NaN keys can't be looked up.\n")
+		g.printf("%s := make([]%s,0,len(%s))\n",keys,t.key.src,expr)
+		g.printf("%s := make([]%s,0,len(%s))\n",vals,t.elem.src,expr)
+		g.printf("for %s,%s := range %s {\n%s = append(%s,%s)\n%s = append(%s,%s)\n}\n",k,v,expr,keys,keys,k,vals,vals,v)
+		g.printf("// Sort the entries by their encoded keys.\n")
+		g.printf("%s := new(bytes.Buffer)\n",buf)
+		g.printf("%s := preciseio.PreciseWriterFromPool()\n",kw)
+		g.printf("%s.W = %s\n",kw,buf)
+		g.printf("%s := make([]int,len(%s)+1)\n",offs,keys)
+		g.printf("for %s := range %s {\n",i,keys)
+		g.write(t.key,keys+"["+i+"]",kw,true)
+		g.printf("%s[%s+1] = %s.Len()\n",offs,i,buf)
+		g.printf("}\n")
+		g.printf("%s.PutToPool()\n",kw)
+		g.printf("%s := make([]int,len(%s))\n",idx,keys)
+		g.printf("for %s := range %s { %s[%s] = %s }\n",i,idx,idx,i,i)
+		g.printf("sort.Slice(%s,func(i,j int) bool {\n",idx)
+		g.printf("a,b := %s[i],%s[j]\n",idx,idx)
+		g.printf("return bytes.Compare(%s.Bytes()[%s[a]:%s[a+1]],%s.Bytes()[%s[b]:%s[b+1]])<0\n",buf,offs,offs,buf,offs,offs)
+		g.printf("})\n")
+		g.printf("for _,%s := range %s {\n",i,idx)
+		g.printf("if _,e := %s.W.Write(%s.Bytes()[%s[%s]:%s[%s+1]])"+check,w,buf,offs,i,offs,i)
+		g.write(t.elem,vals+"["+i+"]",w,true)
+		g.printf("}\n")
+		g.printf("}\n")
+	}
+}
+
+// Emits code reading a value of type t from r into the addressable expression target.
+func (g *generator) read(t *gtype, target, r string) {
+	switch t.kind {
+	case tkBasic:
+		v := g.temp("v")
+		switch t.basic {
+		case "bool":
+			g.printf("%s,e := %s.R.ReadByte()\nif e!=nil { return e }\n",v,r)
+			g.printf("%s = %s(%s!=0)\n",target,t.src,v)
+		case "string":
+			g.printf("%s,e := %s.ReadBlob()\nif e!=nil { return e }\n",v,r)
+			g.printf("%s = %s(%s)\n",target,t.src,v)
+		case "int8":
+			g.printf("%s,e := %s.R.ReadByte()\nif e!=nil { return e }\n",v,r)
+			g.printf("%s = %s(int8(%s))\n",target,t.src,v)
+		case "uint8":
+			g.printf("%s,e := %s.R.ReadByte()\nif e!=nil { return e }\n",v,r)
+			g.printf("%s = %s(%s)\n",target,t.src,v)
+		case "int","int16","int32","int64":
+			g.printf("%s,e := %s.ReadVarint()\nif e!=nil { return e }\n",v,r)
+			g.printf("%s = %s(%s)\n",target,t.src,v)
+		case "uint","uint16","uint32","uint64":
+			g.printf("%s,e := %s.ReadUvarint()\nif e!=nil { return e }\n",v,r)
+			g.printf("%s = %s(%s)\n",target,t.src,v)
+		case "float32":
+			g.printf("%s,e := %s.ReadUint32()\nif e!=nil { return e }\n",v,r)
+			g.printf("%s = %s(math.Float32frombits(%s))\n",target,t.src,v)
+		case "float64":
+			g.printf("%s,e := %s.ReadUint64()\nif e!=nil { return e }\n",v,r)
+			g.printf("%s = %s(math.Float64frombits(%s))\n",target,t.src,v)
+		case "complex64":
+			i := g.temp("i")
+			g.printf("%s,e := %s.ReadUint32()\nif e!=nil { return e }\n",v,r)
+			g.printf("%s,e := %s.ReadUint32()\nif e!=nil { return e }\n",i,r)
+			g.printf("%s = %s(complex(math.Float32frombits(%s),math.Float32frombits(%s)))\n",target,t.src,v,i)
+		case "complex128":
+			i := g.temp("i")
+			g.printf("%s,e := %s.ReadUint64()\nif e!=nil { return e }\n",v,r)
+			g.printf("%s,e := %s.ReadUint64()\nif e!=nil { return e }\n",i,r)
+			g.printf("%s = %s(complex(math.Float64frombits(%s),math.Float64frombits(%s)))\n",target,t.src,v,i)
+		}
+	case tkTime:
+		s,ns := g.temp("s"),g.temp("ns")
+		g.printf("%s,e := %s.ReadVarint()\nif e!=nil { return e }\n",s,r)
+		g.printf("%s,e := %s.ReadUvarint()\nif e!=nil { return e }\n",ns,r)
+		g.printf("if %s>=1e9 { return serializer.EInvalidTime }\n",ns)
+		g.printf("%s = time.Unix(%s,int64(%s)).UTC()\n",target,s,ns)
+	case tkBytes:
+		v := g.temp("v")
+		g.printf("%s,e := %s.ReadBlob()\nif e!=nil { return e }\n",v,r)
+		g.printf("%s = %s(%s)\n",target,t.src,v)
+	case tkStruct:
+		g.printf("if e := %s.Read(%s)"+check,target,r)
+	case tkPtr:
+		b := g.temp("b")
+		g.printf("%s,e := %s.R.ReadByte()\nif e!=nil { return e }\n",b,r)
+		g.printf("if %s==0 {\n%s = nil\n} else {\n",b,target)
+		g.printf("%s = new(%s)\n",target,t.elem.src)
+		g.read(t.elem,"(*"+target+")",r)
+		g.printf("}\n")
+	case tkArray:
+		i := g.temp("i")
+		g.printf("for %s := range %s {\n",i,target)
+		g.read(t.elem,target+"["+i+"]",r)
+		g.printf("}\n")
+	case tkSlice:
+		n,i := g.temp("n"),g.temp("i")
+		g.printf("%s,e := %s.ReadListLength()\nif e!=nil { return e }\n",n,r)
+		g.printf("if %s==0 {\n%s = nil\n} else {\n",n,target)
+		g.printf("%s = make(%s,%s)\n",target,t.src,n)
+		g.printf("for %s := range %s {\n",i,target)
+		g.read(t.elem,target+"["+i+"]",r)
+		g.printf("}\n}\n")
+	case tkMap:
+		b,n,m,i,k,v := g.temp("b"),g.temp("n"),g.temp("m"),g.temp("i"),g.temp("k"),g.temp("v")
+		g.printf("%s,e := %s.R.ReadByte()\nif e!=nil { return e }\n",b,r)
+		g.printf("if %s==0 {\n%s = nil\n} else {\n",b,target)
+		g.printf("%s,e := %s.ReadListLength()\nif e!=nil { return e }\n",n,r)
+		g.printf("%s := make(%s,%s)\n",m,t.src,n)
+		g.printf("for %s := 0 ; %s<%s ; %s++ {\n",i,i,n,i)
+		g.printf("var %s %s\nvar %s %s\n",k,t.key.src,v,t.elem.src)
+		g.read(t.key,k,r)
+		g.read(t.elem,v,r)
+		g.printf("%s[%s] = %s\n",m,k,v)
+		g.printf("}\n%s = %s\n}\n",target,m)
+	}
+}
+
+func (g *generator) generate() ([]byte,error) {
+	var body bytes.Buffer
+	for _,s := range g.structs {
+		g.buf.Reset()
+		g.printf("// Write serializes x like serializer.ForStructInline(new(%s)).\n",s.name)
+		g.printf("func (x *%s) Write(w *preciseio.PreciseWriter) error {\n",s.name)
+		for _,f := range s.fields {
+			g.write(f.typ,"x."+f.name,"w",true)
+		}
+		g.printf("return nil\n}\n\n")
+		g.printf("// Read deserializes x like serializer.ForStructInline(new(%s)).\n",s.name)
+		g.printf("func (x *%s) Read(r preciseio.PreciseReader) error {\n",s.name)
+		for _,f := range s.fields {
+			g.read(f.typ,"x."+f.name,"r")
+		}
+		g.printf("return nil\n}\n\n")
+		body.Write(g.buf.Bytes())
+	}
+	
+	var out bytes.Buffer
+	fmt.Fprintf(&out,"// Code generated by serializergen. DO NOT EDIT.\n\npackage %s\n\n",g.pkg)
+	imports := []string{"github.com/byte-mug/golibs/preciseio"}
+	for imp := range g.imports { imports = append(imports,imp) }
+	sort.Strings(imports)
+	for _,imp := range imports { fmt.Fprintf(&out,"import %q\n",imp) }
+	out.WriteString("\n")
+	out.Write(body.Bytes())
+	return format.Source(out.Bytes())
+}
+
+// Generates the code for all annotated structures in the given files.
+func Generate(files []*ast.File) ([]byte,error) {
+	g := &generator{types:make(map[string]ast.Expr),imports:make(map[string]bool)}
+	annotated := make(map[string]bool)
+	var specs []*ast.TypeSpec
+	for _,f := range files {
+		g.pkg = f.Name.Name
+		for _,d := range f.Decls {
+			gd,ok := d.(*ast.GenDecl)
+			if !ok || gd.Tok!=token.TYPE { continue }
+			for _,sp := range gd.Specs {
+				ts := sp.(*ast.TypeSpec)
+				g.types[ts.Name.Name] = ts.Type
+				if _,ok := ts.Type.(*ast.StructType); !ok { continue }
+				if isAnnotated(ts.Doc) || (len(gd.Specs)==1 && isAnnotated(gd.Doc)) {
+					annotated[ts.Name.Name] = true
+					specs = append(specs,ts)
+				}
+			}
+		}
+	}
+	sort.Slice(specs,func(i,j int) bool { return specs[i].Name.Name<specs[j].Name.Name })
+	for _,ts := range specs {
+		fields,err := g.fields(ts.Name.Name,ts.Type.(*ast.StructType),annotated)
+		if err!=nil { return nil,err }
+		g.structs = append(g.structs,&gstruct{ts.Name.Name,fields})
+	}
+	return g.generate()
+}
+
+// Parses the non-test Go files of dir, except the output file.
+func parseDir(dir, output string) ([]*ast.File,error) {
+	fset := token.NewFileSet()
+	pkgs,err := parser.ParseDir(fset,dir,func(fi os.FileInfo) bool {
+		n := fi.Name()
+		return !strings.HasSuffix(n,"_test.go") && n!=filepath.Base(output)
+	},parser.ParseComments)
+	if err!=nil { return nil,err }
+	if len(pkgs)!=1 { return nil,fmt.Errorf("expected exactly one package in %s, found %d",dir,len(pkgs)) }
+	var files []*ast.File
+	for _,p := range pkgs {
+		names := make([]string,0,len(p.Files))
+		for n := range p.Files { names = append(names,n) }
+		sort.Strings(names)
+		for _,n := range names { files = append(files,p.Files[n]) }
+	}
+	return files,nil
+}
+
+func main() {
+	output := flag.String("o","serializer_gen.go","output file (relative to the directory)")
+	flag.Parse()
+	dir := "."
+	if flag.NArg()>0 { dir = flag.Arg(0) }
+	out := filepath.Join(dir,*output)
+	files,err := parseDir(dir,out)
+	if err==nil {
+		var src []byte
+		src,err = Generate(files)
+		if err==nil { err = ioutil.WriteFile(out,src,0644) }
+	}
+	if err!=nil {
+		fmt.Fprintln(os.Stderr,"serializergen:",err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The checked-in example must match the output of the current generator.
+func TestExampleUpToDate(t *testing.T) {
+	dir := filepath.Join("internal","example")
+	out := filepath.Join(dir,"serializer_gen.go")
+	files,err := parseDir(dir,out)
+	if err!=nil { t.Fatal(err) }
+	src,err := Generate(files)
+	if err!=nil { t.Fatal(err) }
+	old,err := ioutil.ReadFile(out)
+	if err!=nil { t.Fatal(err) }
+	if !bytes.Equal(src,old) { t.Fatalf("%s is out of date, run go generate",out) }
+}
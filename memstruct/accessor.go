@@ -24,7 +24,6 @@ SOFTWARE.
 package memstruct
 
 import "reflect"
-import "unsafe"
 
 type ro_t uint8
 const (
@@ -32,39 +31,44 @@ const (
 	ro_ptr
 	ro_bytes
 	ro_struct
+	ro_array
+	ro_atomic
 )
 
 type reflectType struct{
-	temp reflect.Type
-	size int
-	ro_t ro_t
-	ftyp []reflectType
+	temp  reflect.Type
+	size  int
+	align int
+	ro_t  ro_t
+	ftyp  []reflectType
+	offs  []int // field offsets, if ro_struct
+	alen  int   // array length, if ro_array
 }
 
 type reflectObject struct{
 	*reflectType
-	pset *unsafe.Pointer
-	bset *[]byte
+	leaf
 	fobj []reflectObject
 }
 
 func (r *reflectObject) init1(vl reflect.Value) {
-	if ftl := len(r.ftyp); ftl>0 {
+	if r.ro_t==ro_array {
+		r.fobj = make([]reflectObject,r.alen)
+	} else if ftl := len(r.ftyp); ftl>0 {
 		r.fobj = make([]reflectObject,ftl)
 	} else {
 		r.fobj = nil
 	}
 	switch r.ro_t {
-	case ro_ptr:
-		/*
-		 * The value 'vl' is something like *uint32,
-		 * so 'vl.Addr()' is something like **uint32
-		 * which, in turn, is straight a pointer to a pointer,
-		 * which validly is *unsafe.Pointer
-		 */
-		r.pset = (*unsafe.Pointer)((unsafe.Pointer)(vl.Addr().Pointer()))
-	case ro_bytes:
-		r.bset = vl.Addr().Interface().(*[]byte)
+	case ro_ptr,ro_bytes,ro_atomic:
+		r.leaf.init(r.reflectType,vl)
+	}
+	if r.ro_t==ro_array {
+		for i := range r.fobj {
+			r.fobj[i].reflectType = &r.ftyp[0]
+			r.fobj[i].init1(vl.Index(i))
+		}
+		return
 	}
 	for i := range r.fobj {
 		r.fobj[i].reflectType = &r.ftyp[i]
@@ -74,9 +78,12 @@ func (r *reflectObject) init1(vl reflect.Value) {
 func (r *reflectObject) setBytes(buf []byte) {
 	if len(buf)!=r.size { panic("invalid size") }
 	switch r.ro_t {
-	case ro_ptr: *r.pset = unsafe.Pointer(&buf[0])
-	case ro_bytes: *r.bset = buf[:r.size:r.size]
+	case ro_ptr,ro_bytes,ro_atomic: r.leaf.set(r.reflectType,buf)
 	case ro_struct:
+		for i := range r.fobj {
+			r.fobj[i].setBytes(buf[r.offs[i]:][:r.fobj[i].size])
+		}
+	case ro_array:
 		for i := range r.fobj {
 			sz := r.fobj[i].size
 			r.fobj[i].setBytes(buf[:sz])
@@ -84,6 +91,15 @@ func (r *reflectObject) setBytes(buf []byte) {
 		}
 	}
 }
+func (r *reflectObject) flush() {
+	switch r.ro_t {
+	case ro_ptr,ro_bytes,ro_atomic: r.leaf.flush(r.reflectType)
+	case ro_struct,ro_array:
+		for i := range r.fobj {
+			r.fobj[i].flush()
+		}
+	}
+}
 
 type topLevelObject struct{
 	reflectObject
@@ -93,10 +109,10 @@ type topLevelObject struct{
 func (r *topLevelObject) init2() {
 	var targ,self reflect.Value
 	switch r.ro_t {
-	case ro_ptr, ro_bytes:
+	case ro_ptr, ro_bytes, ro_atomic:
 		self = reflect.New(r.temp)
 		targ = self.Elem()
-	case ro_struct:
+	case ro_struct,ro_array:
 		self = reflect.New(r.temp)
 		targ = self.Elem()
 		r.val = self.Interface()
@@ -105,13 +121,19 @@ func (r *topLevelObject) init2() {
 	r.reflectObject.init1(targ)
 }
 func (r *topLevelObject) SetBytes(buf []byte) {
+	if len(buf)!=r.size { panic("invalid size") }
+	checkAlign(buf,r.align)
 	r.setBytes(buf)
 }
+func (r *topLevelObject) Flush() {
+	r.flush()
+}
 func (r *topLevelObject) Value() interface{} {
 	if r.val==nil { return r.rval.Interface() }
 	return r.val
 }
 var _ Instance = (*topLevelObject)(nil)
+var _ Flusher = (*topLevelObject)(nil)
 
 type iAccessType struct{
 	reflectType
@@ -125,5 +147,8 @@ func (at *iAccessType) New() Instance {
 func (at *iAccessType) Len() int {
 	return at.size
 }
+func (at *iAccessType) NewSlice(buf []byte) []Instance {
+	return newSlice(at,buf)
+}
 var _ AccessType = (*iAccessType)(nil)
-
+var _ SliceMaker = (*iAccessType)(nil)
@@ -0,0 +1,97 @@
+//go:build memstruct_safe
+// +build memstruct_safe
+
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package memstruct
+
+import "reflect"
+import "encoding/binary"
+
+var nE = binary.NativeEndian
+
+/*
+The unsafe-free implementation: Pointer fields point to separate variables.
+SetBytes copies the buffer into the variables, Flush copies them back.
+Byte slices (including BE32 etc.) still refer to the buffer.
+*/
+type leaf struct{
+	vl  reflect.Value
+	buf []byte
+}
+
+func (l *leaf) init(t *reflectType, vl reflect.Value) {
+	switch t.ro_t {
+	case ro_ptr:
+		nv := reflect.New(t.temp.Elem())
+		vl.Set(nv)
+		l.vl = nv.Elem()
+	case ro_atomic:
+		var nv reflect.Value
+		if t.size==4 { nv = reflect.New(reflect.TypeOf(uint32(0))) } else { nv = reflect.New(reflect.TypeOf(uint64(0))) }
+		vl.Addr().Interface().(atomicBinder).bind(nv.Interface())
+		l.vl = nv.Elem()
+	case ro_bytes:
+		l.vl = vl
+	}
+}
+func (l *leaf) set(t *reflectType, buf []byte) {
+	switch t.ro_t {
+	case ro_ptr,ro_atomic:
+		l.buf = buf
+		var u uint64
+		switch t.size {
+		case 1: u = uint64(buf[0])
+		case 2: u = uint64(nE.Uint16(buf))
+		case 4: u = uint64(nE.Uint32(buf))
+		case 8: u = nE.Uint64(buf)
+		}
+		switch l.vl.Kind() {
+		case reflect.Int8: l.vl.SetInt(int64(int8(u)))
+		case reflect.Int16: l.vl.SetInt(int64(int16(u)))
+		case reflect.Int32: l.vl.SetInt(int64(int32(u)))
+		case reflect.Int64: l.vl.SetInt(int64(u))
+		default: l.vl.SetUint(u)
+		}
+	case ro_bytes:
+		l.vl.Set(reflect.ValueOf(buf[:t.size:t.size]).Convert(t.temp))
+	}
+}
+func (l *leaf) flush(t *reflectType) {
+	if (t.ro_t!=ro_ptr && t.ro_t!=ro_atomic) || l.buf==nil { return }
+	var u uint64
+	switch l.vl.Kind() {
+	case reflect.Int8,reflect.Int16,reflect.Int32,reflect.Int64: u = uint64(l.vl.Int())
+	default: u = l.vl.Uint()
+	}
+	switch t.size {
+	case 1: l.buf[0] = byte(u)
+	case 2: nE.PutUint16(l.buf,uint16(u))
+	case 4: nE.PutUint32(l.buf,uint32(u))
+	case 8: nE.PutUint64(l.buf,u)
+	}
+}
+
+// Without unsafe, the address of the buffer can not be determined.
+func checkAlign(buf []byte, align int) {}
@@ -0,0 +1,78 @@
+//go:build !memstruct_safe
+// +build !memstruct_safe
+
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package memstruct
+
+import "reflect"
+import "unsafe"
+
+// The default implementation: The fields point directly into the buffer.
+type leaf struct{
+	pset *unsafe.Pointer
+	bset *[]byte
+}
+
+func (l *leaf) init(t *reflectType, vl reflect.Value) {
+	switch t.ro_t {
+	case ro_ptr:
+		/*
+		 * The value 'vl' is something like *uint32,
+		 * so 'vl.Addr()' is something like **uint32
+		 * which, in turn, is straight a pointer to a pointer,
+		 * which validly is *unsafe.Pointer
+		 */
+		l.pset = (*unsafe.Pointer)((unsafe.Pointer)(vl.Addr().Pointer()))
+	case ro_atomic:
+		/*
+		 * Atomic32 and Atomic64 consist of a single pointer,
+		 * so their address is the address of that pointer.
+		 */
+		l.pset = (*unsafe.Pointer)((unsafe.Pointer)(vl.Addr().Pointer()))
+	case ro_bytes:
+		/*
+		 * The value 'vl' is either a []byte or a type
+		 * like BE32, which is a []byte as well.
+		 */
+		l.bset = (*[]byte)((unsafe.Pointer)(vl.Addr().Pointer()))
+	}
+}
+func (l *leaf) set(t *reflectType, buf []byte) {
+	switch t.ro_t {
+	case ro_ptr: *l.pset = unsafe.Pointer(&buf[0])
+	case ro_atomic:
+		if uintptr(unsafe.Pointer(&buf[0]))%uintptr(t.size)!=0 { panic("misaligned atomic field") }
+		*l.pset = unsafe.Pointer(&buf[0])
+	case ro_bytes: *l.bset = buf[:t.size:t.size]
+	}
+}
+
+// The fields are the buffer, there is nothing to write back.
+func (l *leaf) flush(t *reflectType) {}
+
+func checkAlign(buf []byte, align int) {
+	if align<2 || len(buf)==0 { return }
+	if uintptr(unsafe.Pointer(&buf[0]))%uintptr(align)!=0 { panic("misaligned buffer") }
+}
@@ -0,0 +1,74 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package memstruct
+
+import "reflect"
+import "sync/atomic"
+
+/*
+Atomic accessor types for counters in shared memory (for example mmap'ed
+files, that are updated by several processes).
+
+	type Header struct{
+		Records memstruct.Atomic64
+		Writers memstruct.Atomic32
+	}
+	
+	hdr := inst.Value().(*Header)
+	id := hdr.Records.Add(1)
+
+SetBytes() panics, if an atomic field is not aligned to its size.
+
+If the package is built with the "memstruct_safe" tag, the operations are
+atomic only within the process, and Flush() must be called to write
+the values back.
+*/
+type Atomic32 struct{
+	p *uint32 // Must be the only field, see leaf.init().
+}
+func (a Atomic32) Load() uint32 { return atomic.LoadUint32(a.p) }
+func (a Atomic32) Store(v uint32) { atomic.StoreUint32(a.p,v) }
+func (a Atomic32) Add(delta uint32) uint32 { return atomic.AddUint32(a.p,delta) }
+func (a Atomic32) Swap(v uint32) uint32 { return atomic.SwapUint32(a.p,v) }
+func (a Atomic32) CompareAndSwap(old, nw uint32) bool { return atomic.CompareAndSwapUint32(a.p,old,nw) }
+func (a *Atomic32) bind(p interface{}) { a.p = p.(*uint32) }
+
+type Atomic64 struct{
+	p *uint64 // Must be the only field, see leaf.init().
+}
+func (a Atomic64) Load() uint64 { return atomic.LoadUint64(a.p) }
+func (a Atomic64) Store(v uint64) { atomic.StoreUint64(a.p,v) }
+func (a Atomic64) Add(delta uint64) uint64 { return atomic.AddUint64(a.p,delta) }
+func (a Atomic64) Swap(v uint64) uint64 { return atomic.SwapUint64(a.p,v) }
+func (a Atomic64) CompareAndSwap(old, nw uint64) bool { return atomic.CompareAndSwapUint64(a.p,old,nw) }
+func (a *Atomic64) bind(p interface{}) { a.p = p.(*uint64) }
+
+type atomicBinder interface{
+	bind(p interface{})
+}
+
+var atomicSizes = map[reflect.Type]int{
+	reflect.TypeOf(Atomic32{}): 4,
+	reflect.TypeOf(Atomic64{}): 8,
+}
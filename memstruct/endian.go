@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package memstruct
+
+import "encoding/binary"
+import "reflect"
+
+var (
+	bE = binary.BigEndian
+	lE = binary.LittleEndian
+)
+
+/*
+Endianness-aware accessor types. Unlike pointers like *uint32, which use
+the host byte order, these types have a fixed byte order, so structures can
+be shared across architectures. They can be used as struct fields:
+
+	type Record struct{
+		Size  memstruct.BE32
+		Flags memstruct.BE16
+		Stamp memstruct.LE64
+	}
+	
+	rec := inst.Value().(*Record)
+	rec.Size.Set(rec.Size.Get()+1)
+*/
+type BE16 []byte
+func (b BE16) Get() uint16 { return bE.Uint16(b) }
+func (b BE16) Set(v uint16) { bE.PutUint16(b,v) }
+
+type BE32 []byte
+func (b BE32) Get() uint32 { return bE.Uint32(b) }
+func (b BE32) Set(v uint32) { bE.PutUint32(b,v) }
+
+type BE64 []byte
+func (b BE64) Get() uint64 { return bE.Uint64(b) }
+func (b BE64) Set(v uint64) { bE.PutUint64(b,v) }
+
+type LE16 []byte
+func (b LE16) Get() uint16 { return lE.Uint16(b) }
+func (b LE16) Set(v uint16) { lE.PutUint16(b,v) }
+
+type LE32 []byte
+func (b LE32) Get() uint32 { return lE.Uint32(b) }
+func (b LE32) Set(v uint32) { lE.PutUint32(b,v) }
+
+type LE64 []byte
+func (b LE64) Get() uint64 { return lE.Uint64(b) }
+func (b LE64) Set(v uint64) { lE.PutUint64(b,v) }
+
+var endianSizes = map[reflect.Type]int{
+	reflect.TypeOf(BE16(nil)): 2,
+	reflect.TypeOf(BE32(nil)): 4,
+	reflect.TypeOf(BE64(nil)): 8,
+	reflect.TypeOf(LE16(nil)): 2,
+	reflect.TypeOf(LE32(nil)): 4,
+	reflect.TypeOf(LE64(nil)): 8,
+}
@@ -38,6 +38,11 @@ Implements orthogonal structures.
 	fmt.Println(*ms.Field1)
 	fmt.Println(*ms.Field2)
 	fmt.Println(ms.MyData)
+
+By default, pointer fields point directly into the buffer, which requires the
+"unsafe" package. If the package is built with the "memstruct_safe" build tag,
+a fallback without "unsafe" is used instead: SetBytes() copies the values in,
+and Flush() copies them back.
 */
 package memstruct
 
@@ -48,6 +53,7 @@ import "reflect"
 Supported types:
 
 	struct // instance.Value() returns *struct
+	[N]T   // where T is any supported type; instance.Value() returns *[N]T
 	*int8
 	*int16
 	*int32
@@ -57,11 +63,30 @@ Supported types:
 	*uint32
 	*uint64
 	[]byte // only as struct-field with `bytes:"..."` tag.
+	BE16, BE32, BE64 // big endian accessors
+	LE16, LE32, LE64 // little endian accessors
+	Atomic32, Atomic64 // atomic accessors
 */
 func MakeAccessType(i interface{}) AccessType {
+	return MakeAccessTypeLayout(i,Packed)
+}
+
+type Layout uint8
+const (
+	// The fields are stored back-to-back without any padding (default).
+	Packed Layout = iota
+	
+	// Every field is aligned to its size, and structures are padded
+	// to their alignment, just like a C compiler would do it.
+	// SetBytes() panics, if the buffer is not aligned accordingly.
+	Aligned
+)
+
+// Like MakeAccessType, but with an explicit memory layout.
+func MakeAccessTypeLayout(i interface{}, l Layout) AccessType {
 	tp := reflect.Indirect(reflect.ValueOf(i)).Type()
 	at := new(iAccessType)
-	at.buildType(tp)
+	at.buildType(tp,l)
 	return at
 }
 
@@ -74,3 +99,42 @@ type Instance interface{
 	SetBytes([]byte)
 }
 
+// Implemented by Instances, that need to write their values back into the bytes.
+type Flusher interface{
+	Flush()
+}
+
+/*
+Writes the values of inst back into its bytes. This is only needed, if the
+package is built with the "memstruct_safe" tag, where pointer fields
+are copied in by SetBytes() instead of pointing into the bytes.
+Instances that do not implement Flusher are left untouched.
+*/
+func Flush(inst Instance) {
+	if f,ok := inst.(Flusher); ok { f.Flush() }
+}
+
+// Implemented by AccessTypes, that overlay a buffer with consecutive records
+// themselves. The AccessTypes returned by MakeAccessType implement it.
+type SliceMaker interface{
+	// Overlays buf with len(buf)/Len() consecutive records.
+	NewSlice(buf []byte) []Instance
+}
+
+// Overlays buf with len(buf)/at.Len() consecutive records. Uses at.NewSlice(), if at is a SliceMaker.
+func NewSlice(at AccessType, buf []byte) []Instance {
+	if sm,ok := at.(SliceMaker); ok { return sm.NewSlice(buf) }
+	return newSlice(at,buf)
+}
+func newSlice(at AccessType, buf []byte) []Instance {
+	size := at.Len()
+	if size==0 { return nil }
+	insts := make([]Instance,len(buf)/size)
+	for i := range insts {
+		inst := at.New()
+		inst.SetBytes(buf[:size])
+		buf = buf[size:]
+		insts[i] = inst
+	}
+	return insts
+}
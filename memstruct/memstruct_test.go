@@ -0,0 +1,158 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package memstruct
+
+import "encoding/binary"
+import "bytes"
+import "testing"
+
+type testRecord struct{
+	A *uint32
+	B *int16
+	D []byte `bytes:"3"`
+	E BE32
+	F LE16
+}
+
+func TestPointerFields(t *testing.T) {
+	at := MakeAccessType(new(testRecord))
+	if at.Len()!=15 { t.Fatalf("Len() = %d, want 15",at.Len()) }
+	buf := make([]byte,15)
+	binary.NativeEndian.PutUint32(buf,7)
+	inst := at.New()
+	inst.SetBytes(buf)
+	r := inst.Value().(*testRecord)
+	if *r.A!=7 { t.Fatalf("A = %d, want 7",*r.A) }
+	*r.A = 42
+	*r.B = -2
+	copy(r.D,"abc")
+	r.E.Set(0x01020304)
+	r.F.Set(0x0506)
+	Flush(inst)
+	want := make([]byte,15)
+	binary.NativeEndian.PutUint32(want,42)
+	binary.NativeEndian.PutUint16(want[4:],0xfffe)
+	copy(want[6:],"abc\x01\x02\x03\x04\x06\x05")
+	if !bytes.Equal(buf,want) { t.Fatalf("buffer = %x, want %x",buf,want) }
+}
+
+func TestNoFlush(t *testing.T) {
+	buf := make([]byte,4)
+	inst := MakeAccessType(new(uint32)).New()
+	inst.SetBytes(buf)
+	*inst.Value().(*uint32) = 1
+	// With memstruct_safe, the value lives in a separate variable until Flush.
+	if got := binary.NativeEndian.Uint32(buf); got!=1 && !safeBuild || got!=0 && safeBuild {
+		t.Fatalf("buffer = %d before Flush (safeBuild = %v)",got,safeBuild)
+	}
+}
+
+// An AccessType, that does not implement SliceMaker.
+type plainType struct{ at AccessType }
+func (p plainType) New() Instance { return p.at.New() }
+func (p plainType) Len() int { return p.at.Len() }
+
+func TestNewSlice(t *testing.T) {
+	type rec struct{ A BE16; N [2]*uint8 }
+	at := MakeAccessType(new(rec))
+	buf := []byte{0,1,2,3, 0,4,5,6, 9}
+	insts := at.(SliceMaker).NewSlice(buf)
+	if len(insts)!=2 { t.Fatalf("len = %d, want 2",len(insts)) }
+	for i,inst := range insts {
+		r := inst.Value().(*rec)
+		if r.A.Get()!=uint16(1+i*3) || *r.N[1]!=byte(3+i*3) { t.Errorf("record %d: %d %d",i,r.A.Get(),*r.N[1]) }
+		*r.N[0]++
+		Flush(inst)
+	}
+	if buf[2]!=3 || buf[6]!=6 { t.Fatalf("buffer = %v",buf) }
+	if NewSlice(MakeAccessType(new(struct{})),buf)!=nil { t.Fatal("zero-size type should give nil") }
+	
+	// Other AccessTypes are overlaid record by record.
+	if insts = NewSlice(plainType{at},buf); len(insts)!=2 || *insts[1].Value().(*rec).N[0]!=6 { t.Fatalf("plain AccessType: %d records",len(insts)) }
+}
+
+func TestAlignedLayout(t *testing.T) {
+	type rec struct{
+		A *uint8
+		B *uint32
+		C *uint16
+	}
+	if n := MakeAccessType(new(rec)).Len(); n!=7 { t.Errorf("Packed: Len() = %d, want 7",n) }
+	at := MakeAccessTypeLayout(new(rec),Aligned)
+	if n := at.Len(); n!=12 { t.Fatalf("Aligned: Len() = %d, want 12",n) }
+	buf := alignedBuf(12)
+	binary.NativeEndian.PutUint32(buf[4:],99)
+	binary.NativeEndian.PutUint16(buf[8:],7)
+	inst := at.New()
+	inst.SetBytes(buf)
+	r := inst.Value().(*rec)
+	if *r.B!=99 || *r.C!=7 { t.Fatalf("B = %d, C = %d",*r.B,*r.C) }
+}
+
+func TestAtomic(t *testing.T) {
+	type hdr struct{
+		N Atomic64
+		W Atomic32
+	}
+	buf := alignedBuf(12)
+	inst := MakeAccessType(new(hdr)).New()
+	inst.SetBytes(buf)
+	h := inst.Value().(*hdr)
+	h.N.Add(5)
+	h.N.Add(1)
+	if !h.W.CompareAndSwap(0,3) { t.Fatal("CompareAndSwap failed") }
+	Flush(inst)
+	if binary.NativeEndian.Uint64(buf)!=6 || binary.NativeEndian.Uint32(buf[8:])!=3 {
+		t.Fatalf("buffer = %x",buf)
+	}
+}
+
+func expectPanic(t *testing.T, name string, f func()) {
+	defer func() {
+		if r := recover(); (r!=nil)!=!safeBuild {
+			t.Errorf("%s: panic = %v (safeBuild = %v)",name,r,safeBuild)
+		}
+	}()
+	f()
+}
+
+// Alignment can only be checked with "unsafe", so memstruct_safe never panics.
+func TestMisaligned(t *testing.T) {
+	type hdr struct{ N Atomic64 }
+	buf := alignedBuf(16)[1:9]
+	expectPanic(t,"Packed atomic",func() {
+		MakeAccessType(new(hdr)).New().SetBytes(buf)
+	})
+	type rec struct{ A *uint64 }
+	expectPanic(t,"Aligned",func() {
+		MakeAccessTypeLayout(new(rec),Aligned).New().SetBytes(buf)
+	})
+}
+
+func TestInvalidSize(t *testing.T) {
+	defer func() {
+		if recover()==nil { t.Error("SetBytes accepted a short buffer") }
+	}()
+	MakeAccessType(new(testRecord)).New().SetBytes(make([]byte,3))
+}
@@ -27,19 +27,38 @@ import "reflect"
 import "fmt"
 import "strconv"
 
-func (t *reflectType) buildType(tp reflect.Type) {
+func roundUp(i, align int) int {
+	return (i+align-1)/align*align
+}
+
+func (t *reflectType) buildType(tp reflect.Type, l Layout) {
 	switch tp.Kind() {
-	case reflect.Struct,reflect.Ptr,reflect.Slice: /* smile */
+	case reflect.Struct,reflect.Ptr,reflect.Slice,reflect.Array: /* smile */
 	default: tp = reflect.PtrTo(tp)
 	}
-	t.build(reflect.StructField{Type:tp})
+	t.build(reflect.StructField{Type:tp},l)
 }
-func (t *reflectType) build(sf reflect.StructField) {
+func (t *reflectType) build(sf reflect.StructField, l Layout) {
 	t.temp = sf.Type
+	t.align = 1
 	switch t.temp.Kind() {
-	case reflect.Struct: t.buildStruct()
-	case reflect.Ptr: t.buildPointer()
+	case reflect.Struct:
+		if sz,ok := atomicSizes[t.temp]; ok {
+			t.ro_t = ro_atomic
+			t.size = sz
+			if l==Aligned { t.align = sz }
+			return
+		}
+		t.buildStruct(l)
+	case reflect.Ptr: t.buildPointer(l)
+	case reflect.Array: t.buildArray(sf,l)
 	case reflect.Slice:
+		if sz,ok := endianSizes[t.temp]; ok {
+			t.ro_t = ro_bytes
+			t.size = sz
+			if l==Aligned { t.align = sz }
+			return
+		}
 		if t.temp.Elem().Kind()!=reflect.Uint8 {
 			panic(fmt.Sprintf("unsupported type: %v",t.temp))
 		}
@@ -54,16 +73,32 @@ func (t *reflectType) setSize(sf reflect.StructField) {
 	if i<0 { i = 0 }
 	t.size = i
 }
-func (t *reflectType) buildStruct() {
+func (t *reflectType) buildStruct(l Layout) {
 	t.ftyp = make([]reflectType,t.temp.NumField())
+	t.offs = make([]int,len(t.ftyp))
 	t.ro_t = ro_struct
-	t.size = 0
+	off := 0
 	for i := range t.ftyp {
-		t.ftyp[i].build(t.temp.Field(i))
-		t.size += t.ftyp[i].size
+		t.ftyp[i].build(t.temp.Field(i),l)
+		fa := t.ftyp[i].align
+		if fa>t.align { t.align = fa }
+		off = roundUp(off,fa)
+		t.offs[i] = off
+		off += t.ftyp[i].size
 	}
+	// Pad the structure, so that it can be repeated in arrays.
+	t.size = roundUp(off,t.align)
+}
+func (t *reflectType) buildArray(sf reflect.StructField, l Layout) {
+	t.ftyp = make([]reflectType,1)
+	t.ro_t = ro_array
+	t.alen = t.temp.Len()
+	// The tag is passed to the element, so [N][]byte `bytes:"..."` works.
+	t.ftyp[0].build(reflect.StructField{Type:t.temp.Elem(),Tag:sf.Tag},l)
+	t.align = t.ftyp[0].align
+	t.size = t.alen*t.ftyp[0].size
 }
-func (t *reflectType) buildPointer() {
+func (t *reflectType) buildPointer(l Layout) {
 	t.ro_t = ro_ptr
 	switch t.temp.Elem().Kind() {
 	case reflect.Int8,reflect.Uint8: t.size = 1
@@ -72,5 +107,6 @@ func (t *reflectType) buildPointer() {
 	case reflect.Int64,reflect.Uint64: t.size = 8
 	default: panic(fmt.Sprintf("unsupported pointer type: %v",t.temp))
 	}
+	if l==Aligned { t.align = t.size }
 }
 
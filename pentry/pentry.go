@@ -20,126 +20,485 @@ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 SOFTWARE.
 */
 
+/*
+Reads and writes structures with variable-length fields, similar to pstruct.
+
+Supported field types are bool, all fixed-size integers, arrays, structs,
+strings and slices. Strings and slices are prefixed with their length
+(for slices: the number of elements). The width of the length prefix can be
+chosen with the pentry tag:
+
+	type Record struct{
+		Key   []byte   `pentry:"len8"`    // 1 byte length prefix
+		Name  string   `pentry:"len16"`   // 2 byte length prefix
+		Data  []byte                      // 4 byte length prefix (default)
+		Refs  []uint32 `pentry:"len32"`   // 4 byte length prefix
+		Tags  []string `pentry:"uvarint"` // variable length prefix
+	}
+
+Other values of the pentry tag are rejected with a panic.
+
+Decoding
+
+Decode and DecodeSize set []byte fields to sub-slices of the input buffer, so the
+decoded structure aliases the buffer. If the buffer is going to be reused (for
+example, a buffer obtained from buffer.Get), use ReadCopy or ReadInto instead.
+
+All decoding functions validate the input and return EShortBuffer if a value
+or length prefix does not fit into the remaining buffer. The older Read, ReadSize
+and BufferSizeof keep their signatures and panic on malformed input instead.
+*/
 package pentry
 
 import "reflect"
 import "encoding/binary"
+import "errors"
+import "fmt"
+
+var EShortBuffer = errors.New("Short Buffer")
+var EArenaTooSmall = errors.New("Arena Too Small")
+var ELengthOverflow = errors.New("Length exceeds prefix")
+
+type lenKind uint8
+const (
+	lenDefault lenKind = iota
+	len8
+	len16
+	len32
+	lenUvarint
+)
 
-func sizeof(v reflect.Value) int {
+// Panics on unknown tags, so a typo like "len64" does not silently select the default.
+func fieldLenKind(sf reflect.StructField) lenKind {
+	switch tag := sf.Tag.Get("pentry"); tag {
+	case "": return lenDefault
+	case "len8": return len8
+	case "len16": return len16
+	case "len32": return len32
+	case "uvarint": return lenUvarint
+	default: panic(fmt.Sprintf("pentry: field %s: unknown tag %q",sf.Name,tag))
+	}
+}
+
+func (lk lenKind) size(n int) int {
+	switch lk {
+	case len8: return 1
+	case len16: return 2
+	case lenUvarint:
+		var buf [binary.MaxVarintLen64]byte
+		return binary.PutUvarint(buf[:],uint64(n))
+	}
+	return 4
+}
+
+// The smallest possible length prefix.
+func (lk lenKind) minSize() int {
+	switch lk {
+	case len8,lenUvarint: return 1
+	case len16: return 2
+	}
+	return 4
+}
+
+/*
+Returns the smallest number of bytes, a value of type t can be encoded to.
+It is 0, if and only if every value of t is encoded to nothing.
+*/
+func minSize(t reflect.Type, lk lenKind) int {
+	if fs := fixedSize(t.Kind()); fs>0 { return fs }
+	switch t.Kind() {
+	case reflect.String,reflect.Slice: return lk.minSize()
+	case reflect.Array: return t.Len()*minSize(t.Elem(),lenDefault)
+	case reflect.Struct:
+		size := 0
+		for i,n := 0,t.NumField() ; i<n ; i++ {
+			size += minSize(t.Field(i).Type,fieldLenKind(t.Field(i)))
+		}
+		return size
+	}
+	return 0
+}
+
+/*
+Reads the length prefix and verifies, that the n elements can follow it:
+For strings and []byte, n bytes must follow. For other slices, each element
+takes at least minSize(elem) bytes, which the element reads check in detail.
+*/
+func (lk lenKind) get(buf []byte, bo binary.ByteOrder, elem int) (n int,size int,err error) {
+	var u uint64
+	switch lk {
+	case len8:
+		if len(buf)<1 { return 0,0,EShortBuffer }
+		u,size = uint64(buf[0]),1
+	case len16:
+		if len(buf)<2 { return 0,0,EShortBuffer }
+		u,size = uint64(bo.Uint16(buf)),2
+	case lenUvarint:
+		u,size = binary.Uvarint(buf)
+		if size<=0 { return 0,0,EShortBuffer }
+	default:
+		if len(buf)<4 { return 0,0,EShortBuffer }
+		u,size = uint64(bo.Uint32(buf)),4
+	}
+	// Compare before converting, so the length can't turn negative on 32-bit platforms.
+	// Elements, that are encoded to nothing, are only bounded by the int range.
+	max := uint64(^uint(0)>>1)
+	if elem>0 { max = uint64((len(buf)-size)/elem) }
+	if u>max { return 0,0,EShortBuffer }
+	n = int(u)
+	return
+}
+func (lk lenKind) append(dst []byte, n int, bo binary.ByteOrder) ([]byte,error) {
+	var b []byte
+	switch lk {
+	case len8:
+		if n>0xff { return dst,ELengthOverflow }
+		return append(dst,byte(n)),nil
+	case len16:
+		if n>0xffff { return dst,ELengthOverflow }
+		dst,b = grow(dst,2)
+		bo.PutUint16(b,uint16(n))
+		return dst,nil
+	case lenUvarint:
+		var buf [binary.MaxVarintLen64]byte
+		return append(dst,buf[:binary.PutUvarint(buf[:],uint64(n))]...),nil
+	}
+	if uint64(n)>0xffffffff { return dst,ELengthOverflow }
+	dst,b = grow(dst,4)
+	bo.PutUint32(b,uint32(n))
+	return dst,nil
+}
+
+func sizeof(v reflect.Value, lk lenKind) int {
 	switch v.Kind() {
 	case reflect.Bool,reflect.Int8,reflect.Uint8: return 1
 	case reflect.Int16,reflect.Uint16: return 2
 	case reflect.Int32,reflect.Uint32: return 4
 	case reflect.Int64,reflect.Uint64: return 8
+	case reflect.String:
+		return lk.size(v.Len())+v.Len()
 	case reflect.Slice:
-		if v.Type().Elem().Kind()!=reflect.Uint8 { break }
-		return v.Len()+4
+		n := v.Len()
+		size := lk.size(n)
+		if v.Type().Elem().Kind()==reflect.Uint8 { return size+n }
+		for i := 0 ; i<n ; i++ {
+			size += sizeof(v.Index(i),lenDefault)
+		}
+		return size
 	case reflect.Array:
-		if v.Len()>0 { return v.Len()*sizeof(v.Index(0)) }
+		size := 0
+		for i,n := 0,v.Len() ; i<n ; i++ {
+			size += sizeof(v.Index(i),lenDefault)
+		}
+		return size
 	case reflect.Struct:
 		size := 0
+		t := v.Type()
 		for num,i := v.NumField(),0 ; i<num ; i++ {
-			size += sizeof(v.Field(i))
+			size += sizeof(v.Field(i),fieldLenKind(t.Field(i)))
 		}
 		return size
 	}
 	return 0
 }
 
-func fakeRead(v reflect.Value,buf []byte, bo binary.ByteOrder) (size int) {
-	switch v.Kind() {
+func fixedSize(k reflect.Kind) int {
+	switch k {
 	case reflect.Bool,reflect.Int8,reflect.Uint8: return 1
 	case reflect.Int16,reflect.Uint16: return 2
 	case reflect.Int32,reflect.Uint32: return 4
 	case reflect.Int64,reflect.Uint64: return 8
+	}
+	return 0
+}
+
+type readMode uint8
+const (
+	readAlias readMode = iota
+	readCopy
+	readArena
+)
+
+type decoder struct{
+	bo    binary.ByteOrder
+	mode  readMode
+	arena []byte
+}
+
+func (d *decoder) bytes(b []byte) ([]byte,error) {
+	n := len(b)
+	switch d.mode {
+	case readCopy:
+		nb := make([]byte,n)
+		copy(nb,b)
+		return nb,nil
+	case readArena:
+		if n>len(d.arena) { return nil,EArenaTooSmall }
+		nb := d.arena[:n:n]
+		d.arena = d.arena[n:]
+		copy(nb,b)
+		return nb,nil
+	}
+	return b[:n:n],nil
+}
+
+func (d *decoder) fakeRead(v reflect.Value,buf []byte, lk lenKind) (size int,err error) {
+	if fs := fixedSize(v.Kind()); fs>0 {
+		if len(buf)<fs { return 0,EShortBuffer }
+		return fs,nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		var n int
+		n,size,err = lk.get(buf,d.bo,1)
+		return size+n,err
 	case reflect.Slice:
-		if v.Type().Elem().Kind()!=reflect.Uint8 { break }
-		return int(bo.Uint32(buf))+4
+		var n,sz int
+		et := v.Type().Elem()
+		es := minSize(et,lenDefault)
+		n,size,err = lk.get(buf,d.bo,es)
+		if err!=nil { return }
+		if et.Kind()==reflect.Uint8 { return size+n,nil }
+		if es==0 { return } // The elements occupy no bytes.
+		ev := reflect.Zero(et)
+		for i := 0 ; i<n ; i++ {
+			sz,err = d.fakeRead(ev,buf[size:],lenDefault)
+			size += sz
+			if err!=nil { return }
+		}
 	case reflect.Array:
+		var sz int
 		for i,n := 0,v.Len() ; i<n ; i++ {
-			size += fakeRead(v.Index(i),buf[size:],bo)
+			sz,err = d.fakeRead(v.Index(i),buf[size:],lenDefault)
+			size += sz
+			if err!=nil { return }
 		}
 	case reflect.Struct:
+		var sz int
+		t := v.Type()
 		for i,n := 0,v.NumField() ; i<n ; i++ {
-			size += fakeRead(v.Field(i),buf[size:],bo)
+			sz,err = d.fakeRead(v.Field(i),buf[size:],fieldLenKind(t.Field(i)))
+			size += sz
+			if err!=nil { return }
 		}
 	}
 	return
 }
 
-func read(v reflect.Value,buf []byte, bo binary.ByteOrder) (size int) {
-	if !v.CanSet() { return fakeRead(v,buf,bo) } // Fake-Read
+func (d *decoder) read(v reflect.Value,buf []byte, lk lenKind) (size int,err error) {
+	if !v.CanSet() { return d.fakeRead(v,buf,lk) } // Fake-Read
+	if fs := fixedSize(v.Kind()); fs>0 && len(buf)<fs { return 0,EShortBuffer }
+	bo := d.bo
 	switch v.Kind() {
-	case reflect.Bool:   v.SetBool(buf[0]!=0)                   ; return 1
-	case reflect.Int8:   v.SetInt(int64(int8(buf[0])))          ; return 1
-	case reflect.Int16:  v.SetInt(int64(int16(bo.Uint16(buf)))) ; return 2
-	case reflect.Int32:  v.SetInt(int64(int32(bo.Uint32(buf)))) ; return 4
-	case reflect.Int64:  v.SetInt(int64(bo.Uint64(buf)))        ; return 8
+	case reflect.Bool:   v.SetBool(buf[0]!=0)                   ; return 1,nil
+	case reflect.Int8:   v.SetInt(int64(int8(buf[0])))          ; return 1,nil
+	case reflect.Int16:  v.SetInt(int64(int16(bo.Uint16(buf)))) ; return 2,nil
+	case reflect.Int32:  v.SetInt(int64(int32(bo.Uint32(buf)))) ; return 4,nil
+	case reflect.Int64:  v.SetInt(int64(bo.Uint64(buf)))        ; return 8,nil
 	
-	case reflect.Uint8:  v.SetUint(uint64(buf[0]))         ; return 1
-	case reflect.Uint16: v.SetUint(uint64(bo.Uint16(buf))) ; return 2
-	case reflect.Uint32: v.SetUint(uint64(bo.Uint32(buf))) ; return 4
-	case reflect.Uint64: v.SetUint(bo.Uint64(buf))         ; return 8
+	case reflect.Uint8:  v.SetUint(uint64(buf[0]))         ; return 1,nil
+	case reflect.Uint16: v.SetUint(uint64(bo.Uint16(buf))) ; return 2,nil
+	case reflect.Uint32: v.SetUint(uint64(bo.Uint32(buf))) ; return 4,nil
+	case reflect.Uint64: v.SetUint(bo.Uint64(buf))         ; return 8,nil
+	case reflect.String:
+		var n int
+		n,size,err = lk.get(buf,bo,1)
+		if err!=nil { return }
+		v.SetString(string(buf[size:][:n]))
+		return size+n,nil
 	case reflect.Slice:
-		if v.Type().Elem().Kind()!=reflect.Uint8 { break }
-		bufl := bo.Uint32(buf)
-		v.SetBytes(buf[4:][:bufl])
-		return int(bufl)+4
+		var n,sz int
+		var b []byte
+		et := v.Type().Elem()
+		es := minSize(et,lenDefault)
+		n,size,err = lk.get(buf,bo,es)
+		if err!=nil { return }
+		if et.Kind()==reflect.Uint8 {
+			b,err = d.bytes(buf[size:][:n])
+			if err!=nil { return }
+			v.SetBytes(b)
+			return size+n,nil
+		}
+		nv := reflect.MakeSlice(v.Type(),n,n)
+		for i := 0 ; i<n && es>0 ; i++ {
+			sz,err = d.read(nv.Index(i),buf[size:],lenDefault)
+			size += sz
+			if err!=nil { return }
+		}
+		v.Set(nv)
 	case reflect.Array:
+		var sz int
 		for i,n := 0,v.Len() ; i<n ; i++ {
-			size += read(v.Index(i),buf[size:],bo)
+			sz,err = d.read(v.Index(i),buf[size:],lenDefault)
+			size += sz
+			if err!=nil { return }
 		}
 	case reflect.Struct:
+		var sz int
+		t := v.Type()
 		for i,n := 0,v.NumField() ; i<n ; i++ {
-			size += read(v.Field(i),buf[size:],bo)
+			sz,err = d.read(v.Field(i),buf[size:],fieldLenKind(t.Field(i)))
+			size += sz
+			if err!=nil { return }
 		}
 	}
 	return
 }
-func write(v reflect.Value,buf []byte, bo binary.ByteOrder) (size int) {
+// Extends dst by n bytes. Returns the extended slice and the n new bytes.
+func grow(dst []byte, n int) ([]byte,[]byte) {
+	l := len(dst)
+	if cap(dst)-l < n {
+		nd := make([]byte,l,cap(dst)*2+n)
+		copy(nd,dst)
+		dst = nd
+	}
+	dst = dst[:l+n]
+	return dst,dst[l:]
+}
+
+func appendValue(dst []byte,v reflect.Value, bo binary.ByteOrder, lk lenKind) (_ []byte,err error) {
+	var b []byte
 	switch v.Kind() {
 	case reflect.Bool:
-		if v.Bool() { buf[0]=0xff } else { buf[0]=0 }
-		return 1
-	case reflect.Int8: buf[0] = byte(int8(v.Int()))       ; return 1
-	case reflect.Int16: bo.PutUint16(buf,uint16(v.Int())) ; return 2
-	case reflect.Int32: bo.PutUint32(buf,uint32(v.Int())) ; return 4
-	case reflect.Int64: bo.PutUint64(buf,uint64(v.Int())) ; return 8
+		if v.Bool() { return append(dst,0xff),nil }
+		return append(dst,0),nil
+	case reflect.Int8: return append(dst,byte(int8(v.Int()))),nil
+	case reflect.Int16: dst,b = grow(dst,2) ; bo.PutUint16(b,uint16(v.Int()))
+	case reflect.Int32: dst,b = grow(dst,4) ; bo.PutUint32(b,uint32(v.Int()))
+	case reflect.Int64: dst,b = grow(dst,8) ; bo.PutUint64(b,uint64(v.Int()))
 	
-	case reflect.Uint8: buf[0] = byte(v.Uint())             ; return 1
-	case reflect.Uint16: bo.PutUint16(buf,uint16(v.Uint())) ; return 2
-	case reflect.Uint32: bo.PutUint32(buf,uint32(v.Uint())) ; return 4
-	case reflect.Uint64: bo.PutUint64(buf,v.Uint())         ; return 8
+	case reflect.Uint8: return append(dst,byte(v.Uint())),nil
+	case reflect.Uint16: dst,b = grow(dst,2) ; bo.PutUint16(b,uint16(v.Uint()))
+	case reflect.Uint32: dst,b = grow(dst,4) ; bo.PutUint32(b,uint32(v.Uint()))
+	case reflect.Uint64: dst,b = grow(dst,8) ; bo.PutUint64(b,v.Uint())
+	case reflect.String:
+		dst,err = lk.append(dst,v.Len(),bo)
+		if err!=nil { return }
+		dst = append(dst,v.String()...)
 	case reflect.Slice:
-		if v.Type().Elem().Kind()!=reflect.Uint8 { break }
-		bo.PutUint32(buf,uint32(v.Len()))
-		copy(buf[4:],v.Bytes())
-		return v.Len()+4
+		n := v.Len()
+		dst,err = lk.append(dst,n,bo)
+		if err!=nil { return }
+		if v.Type().Elem().Kind()==reflect.Uint8 {
+			return append(dst,v.Bytes()...),nil
+		}
+		for i := 0 ; i<n ; i++ {
+			dst,err = appendValue(dst,v.Index(i),bo,lenDefault)
+			if err!=nil { return }
+		}
 	case reflect.Array:
 		for i,n := 0,v.Len() ; i<n ; i++ {
-			size += write(v.Index(i),buf[size:],bo)
+			dst,err = appendValue(dst,v.Index(i),bo,lenDefault)
+			if err!=nil { return }
 		}
 	case reflect.Struct:
+		t := v.Type()
 		for i,n := 0,v.NumField() ; i<n ; i++ {
-			size += write(v.Field(i),buf[size:],bo)
+			dst,err = appendValue(dst,v.Field(i),bo,fieldLenKind(t.Field(i)))
+			if err!=nil { return }
 		}
 	}
-	return
+	return dst,nil
 }
 
 func Sizeof(i interface{}) int {
-	return sizeof(reflect.Indirect(reflect.ValueOf(i)))
+	return sizeof(reflect.Indirect(reflect.ValueOf(i)),lenDefault)
+}
+
+// Returns the number of bytes, the encoded structure occupies at the beginning of buf.
+func EncodedSize(i interface{},buf []byte, bo binary.ByteOrder) (int,error) {
+	d := decoder{bo:bo}
+	return d.fakeRead(reflect.Indirect(reflect.ValueOf(i)),buf,lenDefault)
+}
+
+// Like Decode, but also returns the number of bytes consumed.
+func DecodeSize(i interface{},buf []byte, bo binary.ByteOrder) (int,error) {
+	d := decoder{bo:bo}
+	return d.read(reflect.Indirect(reflect.ValueOf(i)),buf,lenDefault)
 }
+
+// Decodes the structure from buf. The []byte fields will alias buf.
+func Decode(i interface{},buf []byte, bo binary.ByteOrder) error {
+	d := decoder{bo:bo}
+	_,err := d.read(reflect.Indirect(reflect.ValueOf(i)),buf,lenDefault)
+	return err
+}
+
+// Like EncodedSize, but panics on malformed input.
+//
+// Deprecated: Use EncodedSize.
 func BufferSizeof(i interface{},buf []byte, bo binary.ByteOrder) int {
-	return fakeRead(reflect.Indirect(reflect.ValueOf(i)),buf,bo)
+	n,err := EncodedSize(i,buf,bo)
+	if err!=nil { panic(err) }
+	return n
 }
+
+// Like DecodeSize, but panics on malformed input.
+//
+// Deprecated: Use DecodeSize.
 func ReadSize(i interface{},buf []byte, bo binary.ByteOrder) int {
-	return read(reflect.Indirect(reflect.ValueOf(i)),buf,bo)
+	n,err := DecodeSize(i,buf,bo)
+	if err!=nil { panic(err) }
+	return n
 }
+
+// Like Decode, but panics on malformed input.
+//
+// Deprecated: Use Decode.
 func Read(i interface{},buf []byte, bo binary.ByteOrder) {
-	read(reflect.Indirect(reflect.ValueOf(i)),buf,bo)
+	if err := Decode(i,buf,bo); err!=nil { panic(err) }
 }
-func Write(i interface{},buf []byte, bo binary.ByteOrder) {
-	write(reflect.Indirect(reflect.ValueOf(i)),buf,bo)
+
+// Decodes the structure from buf. Every []byte field gets its own, newly allocated slice.
+func ReadCopy(i interface{},buf []byte, bo binary.ByteOrder) error {
+	d := decoder{bo:bo,mode:readCopy}
+	_,err := d.read(reflect.Indirect(reflect.ValueOf(i)),buf,lenDefault)
+	return err
+}
+
+// Decodes the structure from buf. The []byte fields are copied into arena.
+// The unused remainder of the arena is returned, so it can be used for the next structure.
+// An arena of len(buf) bytes is always sufficient.
+//
+// If the arena is exhausted, EArenaTooSmall is returned.
+func ReadInto(i interface{},buf []byte, bo binary.ByteOrder, arena []byte) (rest []byte,err error) {
+	d := decoder{bo:bo,mode:readArena,arena:arena}
+	_,err = d.read(reflect.Indirect(reflect.ValueOf(i)),buf,lenDefault)
+	return d.arena,err
+}
+
+// Encodes the structure into buf. The buffer must be at least Sizeof(i) bytes long,
+// otherwise EShortBuffer is returned. If a string or slice is too long for
+// its length prefix, ELengthOverflow is returned.
+func Write(i interface{},buf []byte, bo binary.ByteOrder) error {
+	v := reflect.Indirect(reflect.ValueOf(i))
+	if sizeof(v,lenDefault)>len(buf) { return EShortBuffer }
+	_,err := appendValue(buf[:0],v,bo,lenDefault)
+	return err
 }
 
+// Appends the encoded structure to dst and returns the extended buffer.
+// The buffer is grown as needed, so there is no need to call Sizeof first.
+// On error, dst is returned unchanged.
+func Append(dst []byte,i interface{}, bo binary.ByteOrder) ([]byte,error) {
+	out,err := appendValue(dst,reflect.Indirect(reflect.ValueOf(i)),bo,lenDefault)
+	if err!=nil { return dst,err }
+	return out,nil
+}
+
+// A helper to implement encoding.BinaryMarshaler:
+//
+//	func (r *Record) MarshalBinary() ([]byte,error) { return pentry.MarshalBinary(r,binary.BigEndian) }
+func MarshalBinary(i interface{}, bo binary.ByteOrder) ([]byte,error) {
+	b,err := Append(nil,i,bo)
+	if err!=nil { return nil,err }
+	return b,nil
+}
+
+// A helper to implement encoding.BinaryUnmarshaler. As required by
+// encoding.BinaryUnmarshaler, the structure does not retain data.
+//
+//	func (r *Record) UnmarshalBinary(data []byte) error { return pentry.UnmarshalBinary(r,data,binary.BigEndian) }
+func UnmarshalBinary(i interface{},data []byte, bo binary.ByteOrder) error {
+	return ReadCopy(i,data,bo)
+}
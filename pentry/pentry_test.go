@@ -0,0 +1,184 @@
+/*
+Copyright (c) 2017-2019 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pentry
+
+import "encoding/binary"
+import "bytes"
+import "reflect"
+import "strings"
+import "testing"
+
+type tagged struct{
+	A []byte   `pentry:"len8"`
+	B string   `pentry:"len16"`
+	C []byte
+	D []uint16 `pentry:"len32"`
+	E []string `pentry:"uvarint"`
+}
+
+func expectPanic(t *testing.T, name string, f func()) {
+	t.Helper()
+	defer func() {
+		if recover()==nil { t.Errorf("%s: did not panic",name) }
+	}()
+	f()
+}
+
+func TestLengthTags(t *testing.T) {
+	in := &tagged{[]byte("a"),"bc",[]byte("d"),[]uint16{1,2},[]string{"e"}}
+	want := []byte{
+		1,'a', // len8
+		0,2,'b','c', // len16
+		0,0,0,1,'d', // default: 4 bytes
+		0,0,0,2, 0,1, 0,2, // len32, counting elements
+		1, 0,0,0,1,'e', // uvarint, the elements use the default
+	}
+	b,err := Append(nil,in,binary.BigEndian)
+	if err!=nil { t.Fatal(err) }
+	if !bytes.Equal(b,want) { t.Errorf("Append = %x, want %x",b,want) }
+	if n := Sizeof(in); n!=len(want) { t.Errorf("Sizeof = %d, want %d",n,len(want)) }
+	if n,err := EncodedSize(new(tagged),want,binary.BigEndian); n!=len(want) || err!=nil { t.Errorf("EncodedSize = %d, %v",n,err) }
+	
+	out := new(tagged)
+	if n,err := DecodeSize(out,want,binary.BigEndian); n!=len(want) || err!=nil { t.Fatalf("DecodeSize = %d, %v",n,err) }
+	if !reflect.DeepEqual(out,in) { t.Errorf("got %+v, want %+v",out,in) }
+}
+
+type empties struct{
+	E []struct{}
+	A [2][0]int
+	N uint8
+}
+
+func TestElementCounts(t *testing.T) {
+	// The length prefix of a slice counts elements, not bytes.
+	in := &empties{E:make([]struct{},5),N:7}
+	b,err := MarshalBinary(in,binary.BigEndian)
+	if err!=nil { t.Fatal(err) }
+	if !bytes.Equal(b,[]byte{0,0,0,5,7}) { t.Errorf("encoded %x",b) }
+	out := new(empties)
+	if err = UnmarshalBinary(out,b,binary.BigEndian); err!=nil || !reflect.DeepEqual(out,in) { t.Errorf("got %+v, %v",out,err) }
+	
+	// 3 elements of 2 bytes need 6 bytes.
+	for _,c := range []struct{
+		data []byte
+		err  error
+	}{
+		{[]byte{0,0,0,3, 0,1, 0,2, 0,3},nil},
+		{[]byte{0,0,0,3, 0,1, 0,2, 0},EShortBuffer},
+		{[]byte{0xff,0xff,0xff,0xff, 0,1},EShortBuffer},
+	} {
+		var v struct{ S []uint16 }
+		if err := Decode(&v,c.data,binary.BigEndian); err!=c.err { t.Errorf("%x: got %v, want %v",c.data,err,c.err) }
+		if _,err := EncodedSize(&v,c.data,binary.BigEndian); err!=c.err { t.Errorf("%x: EncodedSize: got %v, want %v",c.data,err,c.err) }
+	}
+}
+
+func TestUnknownTag(t *testing.T) {
+	var v struct{ A []byte `pentry:"len64"` }
+	expectPanic(t,"Sizeof",func() { Sizeof(&v) })
+	expectPanic(t,"Append",func() { Append(nil,&v,binary.BigEndian) })
+	expectPanic(t,"Decode",func() { Decode(&v,make([]byte,8),binary.BigEndian) })
+	defer func() {
+		if r := recover(); r==nil || !strings.Contains(r.(string),`"len64"`) { t.Errorf("panic: %v",r) }
+	}()
+	Sizeof(&v)
+}
+
+func TestTruncated(t *testing.T) {
+	data,err := Append(nil,&tagged{[]byte("a"),"bc",[]byte("d"),[]uint16{1,2},[]string{"e","f"}},binary.BigEndian)
+	if err!=nil { t.Fatal(err) }
+	for i := 0 ; i<len(data) ; i++ {
+		b := data[:i]
+		if _,err := EncodedSize(new(tagged),b,binary.BigEndian); err!=EShortBuffer { t.Errorf("EncodedSize(%d bytes): %v",i,err) }
+		if _,err := DecodeSize(new(tagged),b,binary.BigEndian); err!=EShortBuffer { t.Errorf("DecodeSize(%d bytes): %v",i,err) }
+		if err := ReadCopy(new(tagged),b,binary.BigEndian); err!=EShortBuffer { t.Errorf("ReadCopy(%d bytes): %v",i,err) }
+		if _,err := ReadInto(new(tagged),b,binary.BigEndian,make([]byte,64)); err!=EShortBuffer { t.Errorf("ReadInto(%d bytes): %v",i,err) }
+	}
+	expectPanic(t,"Read",func() { Read(new(tagged),data[:3],binary.BigEndian) })
+	expectPanic(t,"ReadSize",func() { ReadSize(new(tagged),data[:3],binary.BigEndian) })
+	expectPanic(t,"BufferSizeof",func() { BufferSizeof(new(tagged),data[:3],binary.BigEndian) })
+}
+
+func TestReadCopy(t *testing.T) {
+	data,_ := Append(nil,&tagged{A:[]byte("a"),C:[]byte("cc")},binary.BigEndian)
+	alias,cp := new(tagged),new(tagged)
+	if err := Decode(alias,data,binary.BigEndian); err!=nil { t.Fatal(err) }
+	if err := ReadCopy(cp,data,binary.BigEndian); err!=nil { t.Fatal(err) }
+	for i := range data { data[i] = 'x' }
+	if string(cp.A)!="a" || string(cp.C)!="cc" { t.Errorf("ReadCopy aliases the input: %+v",cp) }
+	if string(alias.C)!="xx" { t.Errorf("Decode does not alias the input: %+v",alias) }
+	if cap(alias.A)!=1 { t.Errorf("aliased slice has capacity %d",cap(alias.A)) }
+}
+
+func TestReadInto(t *testing.T) {
+	data,_ := Append(nil,&tagged{A:[]byte("a"),C:[]byte("cc")},binary.BigEndian)
+	arena := make([]byte,10)
+	v := new(tagged)
+	rest,err := ReadInto(v,data,binary.BigEndian,arena)
+	if err!=nil { t.Fatal(err) }
+	if len(rest)!=7 || &rest[0]!=&arena[3] { t.Errorf("%d bytes of the arena left",len(rest)) }
+	if &v.A[0]!=&arena[0] || &v.C[0]!=&arena[1] || cap(v.A)!=1 { t.Error("fields are not placed in the arena") }
+	if string(v.A)!="a" || string(v.C)!="cc" { t.Errorf("got %+v",v) }
+	
+	// A second structure continues in the rest.
+	if rest,err = ReadInto(new(tagged),data,binary.BigEndian,rest); err!=nil || len(rest)!=4 { t.Errorf("second: %d bytes left, %v",len(rest),err) }
+	
+	if _,err = ReadInto(new(tagged),data,binary.BigEndian,arena[:2]); err!=EArenaTooSmall { t.Errorf("got %v, want EArenaTooSmall",err) }
+	if _,err = ReadInto(new(tagged),data,binary.BigEndian,make([]byte,len(data))); err!=nil { t.Errorf("len(buf) bytes: %v",err) }
+}
+
+func TestAppend(t *testing.T) {
+	in := &tagged{A:[]byte("a"),B:"b"}
+	want,err := MarshalBinary(in,binary.LittleEndian)
+	if err!=nil { t.Fatal(err) }
+	dst := []byte("head")
+	out,err := Append(dst[:4:4],in,binary.LittleEndian)
+	if err!=nil { t.Fatal(err) }
+	if !bytes.Equal(out,append([]byte("head"),want...)) { t.Errorf("Append = %q",out) }
+	
+	buf := make([]byte,Sizeof(in))
+	if err = Write(in,buf,binary.LittleEndian); err!=nil || !bytes.Equal(buf,want) { t.Errorf("Write = %x, %v",buf,err) }
+	if err = Write(in,buf[:len(buf)-1],binary.LittleEndian); err!=EShortBuffer { t.Errorf("short buffer: got %v",err) }
+	
+	v := new(tagged)
+	if err = UnmarshalBinary(v,want,binary.LittleEndian); err!=nil || string(v.A)!="a" || v.B!="b" { t.Errorf("got %+v, %v",v,err) }
+}
+
+func TestLengthOverflow(t *testing.T) {
+	long := make([]byte,256)
+	for _,v := range []interface{}{
+		&struct{ A []byte `pentry:"len8"` }{long},
+		&struct{ S string `pentry:"len8"` }{string(long)},
+		&struct{ S []uint8 `pentry:"len16"` }{make([]byte,1<<16)},
+	} {
+		dst := []byte("head")
+		out,err := Append(dst,v,binary.BigEndian)
+		if err!=ELengthOverflow { t.Errorf("%T: got %v, want ELengthOverflow",v,err) }
+		if string(out)!="head" { t.Errorf("%T: dst changed to %q",v,out) }
+		if b,err := MarshalBinary(v,binary.BigEndian); b!=nil || err!=ELengthOverflow { t.Errorf("%T: MarshalBinary = %x, %v",v,b,err) }
+		if err := Write(v,make([]byte,Sizeof(v)),binary.BigEndian); err!=ELengthOverflow { t.Errorf("%T: Write: %v",v,err) }
+	}
+	// The largest lengths still fit.
+	if _,err := Append(nil,&struct{ A []byte `pentry:"len8"` }{long[:255]},binary.BigEndian); err!=nil { t.Error(err) }
+}
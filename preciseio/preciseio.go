@@ -96,6 +96,18 @@ func (pw PreciseWriter) WriteListLength(i int) error {
 	if i>maxblob { return EListTooLong }
 	return pw.WriteUvarint(uint64(i))
 }
+// Writes a 32-bit big-endian integer.
+func (pw PreciseWriter) WriteUint32(i uint32) error {
+	binary.BigEndian.PutUint32(pw.buf,i)
+	_,e := pw.W.Write(pw.buf[:4])
+	return e
+}
+// Writes a 64-bit big-endian integer.
+func (pw PreciseWriter) WriteUint64(i uint64) error {
+	binary.BigEndian.PutUint64(pw.buf,i)
+	_,e := pw.W.Write(pw.buf[:8])
+	return e
+}
 
 
 
@@ -124,4 +136,16 @@ func (pr PreciseReader) ReadListLength() (int,error) {
 	n := int(bn&maxblob)
 	return n,nil
 }
+// Reads a 32-bit big-endian integer.
+func (pr PreciseReader) ReadUint32() (uint32,error) {
+	var b [4]byte
+	_,e := io.ReadFull(pr.R,b[:])
+	return binary.BigEndian.Uint32(b[:]),e
+}
+// Reads a 64-bit big-endian integer.
+func (pr PreciseReader) ReadUint64() (uint64,error) {
+	var b [8]byte
+	_,e := io.ReadFull(pr.R,b[:])
+	return binary.BigEndian.Uint64(b[:]),e
+}
 
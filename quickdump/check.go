@@ -0,0 +1,136 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package quickdump
+
+import "reflect"
+import "fmt"
+import "sync"
+
+// Returned by Marshal and Unmarshal, if a type can not be handled by QuickDump.
+type TypeError struct{
+	Path   string // The path of the offending value, e.g. "main.Foo.Bar[].Baz"
+	Type   reflect.Type
+	Reason string
+}
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("quickdump: %s: %s (%v)",e.Path,e.Reason,e.Type)
+}
+
+var checkCacheLock sync.RWMutex
+var checkCache = make(map[reflect.Type]error)
+
+/*
+Checks once per type, that t can be encoded and decoded. Both directions are
+checked alike, so that anything, that can be marshalled, can be unmarshalled.
+*/
+func checkType(t reflect.Type) error {
+	checkCacheLock.RLock()
+	e,ok := checkCache[t]
+	checkCacheLock.RUnlock()
+	if ok { return e }
+	c := &checker{make(map[reflect.Type]bool)}
+	e = c.check(t,t.String())
+	checkCacheLock.Lock()
+	checkCache[t] = e
+	checkCacheLock.Unlock()
+	return e
+}
+
+type checker struct{
+	visited map[reflect.Type]bool
+}
+
+func isNullable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr: return true
+	case reflect.Struct: return t.NumField()>=2 && t.Field(0).Type.Kind()==reflect.Bool
+	}
+	return false
+}
+func nullableElem(t reflect.Type) reflect.Type {
+	if t.Kind()==reflect.Ptr { return t.Elem() }
+	return t.Field(1).Type
+}
+
+func (c *checker) check(t reflect.Type, path string) error {
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int,reflect.Int8,reflect.Int16,reflect.Int32,reflect.Int64,
+		reflect.Uint,reflect.Uint8,reflect.Uint16,reflect.Uint32,reflect.Uint64,reflect.Uintptr,
+		reflect.Float32,reflect.Float64,reflect.Complex64,reflect.Complex128,
+		reflect.String,reflect.Interface:
+		return nil
+	case reflect.Slice,reflect.Array:
+		return c.check(t.Elem(),path+"[]")
+	case reflect.Map:
+		if e := c.check(t.Key(),path+"[key]"); e!=nil { return e }
+		return c.check(t.Elem(),path+"[]")
+	case reflect.Ptr:
+		if c.visited[t] { return nil }
+		c.visited[t] = true
+		return c.check(t.Elem(),path)
+	case reflect.Struct:
+		if c.visited[t] { return nil }
+		c.visited[t] = true
+		return c.checkStruct(t,path)
+	}
+	return &TypeError{path,t,"unsupported type"}
+}
+
+func (c *checker) checkStruct(t reflect.Type, path string) error {
+	since := 1
+	for _,group := range planFor(t).groups {
+		s := group[0].since
+		if s==0 { s = 1 }
+		if s<since {
+			return &TypeError{path+"."+group[0].name,t.Field(group[0].index).Type,fmt.Sprintf("since=%d field follows a since=%d field",s,since)}
+		}
+		since = s
+		for j,f := range group {
+			if j>0 && f.since!=0 {
+				return &TypeError{path+"."+f.name,t.Field(f.index).Type,"since=N must be on the first member of a variant"}
+			}
+			sf := t.Field(f.index)
+			fpath := path+"."+f.name
+			if sf.PkgPath!="" { return &TypeError{fpath,sf.Type,"unexported field"} }
+			if len(group)>1 && sf.Type.Kind()==reflect.Struct && !isNullable(sf.Type) {
+				return &TypeError{fpath,sf.Type,"variant member must be a pointer or nullable structure"}
+			}
+			ft := sf.Type
+			for _,op := range f.ops {
+				if !isNullable(ft) {
+					tag := "strip"
+					if op==opNullable { tag = "nullable" }
+					return &TypeError{fpath,ft,fmt.Sprintf("%q requires a nullable type",tag)}
+				}
+				ft = nullableElem(ft)
+			}
+			if f.unknown!="" { return &TypeError{fpath,ft,fmt.Sprintf("unknown tag %q",f.unknown)} }
+			if f.compact && ft.Kind()!=reflect.Float32 && ft.Kind()!=reflect.Float64 { return &TypeError{fpath,ft,`"compact" requires a float`} }
+			if e := c.check(ft,fpath); e!=nil { return e }
+		}
+	}
+	return nil
+}
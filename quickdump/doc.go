@@ -29,8 +29,49 @@ than serializer.
 
 QuickDump is capable to serialize structures without any need to previously create serializers for it.
 
-As a limitation: QuickDump is strictly typed - "interface{}" does not work at all. Also
-QuickDump is brittle. Wrong types will cause QuickDump to panic.
+As a limitation: QuickDump is strictly typed - interfaces only work with types registered
+using Register(). Types are checked once, when they are first marshalled or unmarshalled.
+Unsupported types, unexported fields and wrong tags are reported as *TypeError, naming the offending field,
+no matter whether the value is marshalled or unmarshalled.
+
+
+Encoding and Decoding
+
+Besides Marshal() and Unmarshal(), which operate on preciseio, there are Encode() and Decode()
+for any io.Writer and io.Reader, as well as MarshalBytes() and UnmarshalBytes().
+Streams of values are written by an Encoder and read by a Decoder, much like encoding/gob:
+
+	enc := quickdump.NewEncoder(conn)
+	err := enc.Encode(&msg)
+
+	dec := quickdump.NewDecoder(conn)
+	err := dec.Decode(&msg)
+
+Each value is preceded by its length, so a Decoder can skip values (Decode(nil)) and
+reports values that were not read completely. Frames are limited to MaxFrame bytes (1 GiB by default).
+Within a frame, slices and blobs are not pre-allocated beyond the bytes left in it, so the memory
+a frame can claim grows with its actual size. Lower MaxFrame when reading from untrusted peers:
+
+	dec := quickdump.NewDecoder(conn)
+	dec.MaxFrame = 1<<20
+
+
+Floats
+
+Floats are encoded as their IEEE 754 bits, as uvarint. Fields tagged with quickdump:"compact"
+reverse the bytes first (like encoding/gob), so the exponent ends up in the low-order bytes
+and common values like 1.0 or 0.5 take only a few bytes instead of nine:
+
+	Ratio float64 `quickdump:"compact"`
+
+The tag changes the encoding of the field, so it can't be added to existing data.
+It can be combined with strip and nullable, but not be applied to complex numbers or slices.
+
+
+Maps
+
+Maps are NULLable like pointers: a nil map and an empty map are distinct. The entries are
+written in the order of their encoded keys, so equal maps always produce the same output.
 
 
 Nullable
@@ -55,6 +96,46 @@ some kind of Dynamic Typing/Polymorphism.
 		Beta  *Beta   `quickdump:"more,strip"`
 		Gamma *Gamma  `quickdump:"more,strip"`
 	}
+
+
+Interfaces
+
+Interface values are encoded as the name of their dynamic type followed by the value.
+The types must be registered using Register():
+
+	type Shape interface{ Area() float64 }
+
+	type Drawing struct{
+		Shapes []Shape
+	}
+
+	func init() {
+		quickdump.Register("main.Circle",Circle{})
+		quickdump.Register("main.Square",&Square{})
+	}
+
+Unlike Variants, the set of types is open-ended.
+
+Versioning
+
+Fields tagged with quickdump:"since=N" may be appended to a structure later on. A structure,
+that contains any since=N field is written with its length in front, so older data decodes with the
+missing trailing fields left zero, and older programs skip the trailing fields they don't know.
+Fields must be ordered by N and on a Variant, the tag goes to the first member.
+Untagged fields count as since=1: Like the since=1 fields, they are part of the first version
+and a structure lacking them is reported as an error.
+
+Adding the length changes the encoding of the structure, so tag the first field with since=1
+to make a structure versioned from the start:
+
+	type Record struct{
+		ID    uint64   `quickdump:"since=1"`
+		Name  string
+		Email string   `quickdump:"since=2"`
+		Tags  []string `quickdump:"since=2"`
+	}
+
+The encoded fields of a versioned structure must not exceed 16 MiB.
 */
 package quickdump
 
@@ -0,0 +1,175 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package quickdump
+
+import "github.com/byte-mug/golibs/preciseio"
+import "bufio"
+import "bytes"
+import "encoding/binary"
+import "errors"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "sync"
+
+var EFrameTooLong = errors.New("quickdump: frame too long")
+
+// The default frame limit of Encoders and Decoders.
+const DefaultMaxFrame = 1<<30
+
+func marshalTo(w preciseio.Writer, i interface{}) error {
+	pw := preciseio.PreciseWriterFromPool()
+	defer pw.PutToPool()
+	pw.W = w
+	return Marshal(pw,i)
+}
+
+/*
+Encodes the value pointed to by i to w.
+If w has a Flush() method (like *bufio.Writer), it is flushed afterwards.
+*/
+func Encode(w io.Writer, i interface{}) error {
+	if pw,ok := w.(preciseio.Writer); ok {
+		if e := marshalTo(pw,i); e!=nil { return e }
+		if f,ok := w.(interface{ Flush() error }); ok { return f.Flush() }
+		return nil
+	}
+	bw := bufio.NewWriter(w)
+	if e := marshalTo(bw,i); e!=nil { return e }
+	return bw.Flush()
+}
+
+/*
+Decodes a value from r into the value pointed to by i.
+If r does not implement io.ByteReader, it will be wrapped into a bufio.Reader,
+so Decode may read beyond the end of the value.
+*/
+func Decode(r io.Reader, i interface{}) error {
+	pr,ok := r.(preciseio.Reader)
+	if !ok { pr = bufio.NewReader(r) }
+	return Unmarshal(preciseio.PreciseReader{R:pr},i)
+}
+
+// Encodes the value pointed to by i into a new byte slice.
+func MarshalBytes(i interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if e := marshalTo(buf,i); e!=nil { return nil,e }
+	return buf.Bytes(),nil
+}
+
+// Decodes data into the value pointed to by i.
+func UnmarshalBytes(data []byte, i interface{}) error {
+	return Unmarshal(preciseio.PreciseReader{R:bytes.NewReader(data)},i)
+}
+
+/*
+An Encoder writes a stream of values to an io.Writer. Each value is preceded
+by its length as uvarint, so a Decoder can tell the values apart.
+Like gob.Encoder, it is safe for concurrent use.
+*/
+type Encoder struct{
+	// The maximum length of a frame, DefaultMaxFrame if 0. Must be set before use.
+	MaxFrame int
+	
+	mutex sync.Mutex
+	w     io.Writer
+	buf   bytes.Buffer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w:w}
+}
+
+// Encodes the value pointed to by i as a single frame.
+func (enc *Encoder) Encode(i interface{}) error {
+	enc.mutex.Lock()
+	defer enc.mutex.Unlock()
+	enc.buf.Reset()
+	enc.buf.Write(make([]byte,binary.MaxVarintLen64))
+	if e := marshalTo(&enc.buf,i); e!=nil { return e }
+	b := enc.buf.Bytes()
+	n := len(b)-binary.MaxVarintLen64
+	if n>frameLimit(enc.MaxFrame) { return EFrameTooLong }
+	
+	// Put the length right in front of the value, so the frame is written with a single Write.
+	var hdr [binary.MaxVarintLen64]byte
+	h := binary.PutUvarint(hdr[:],uint64(n))
+	b = b[binary.MaxVarintLen64-h:]
+	copy(b,hdr[:h])
+	_,e := enc.w.Write(b)
+	return e
+}
+
+/*
+A Decoder reads a stream of values written by an Encoder.
+Like gob.Decoder, it is safe for concurrent use.
+*/
+type Decoder struct{
+	// The maximum length of a frame, DefaultMaxFrame if 0. Must be set before use.
+	MaxFrame int
+	
+	mutex sync.Mutex
+	r     preciseio.Reader
+}
+
+func frameLimit(n int) int {
+	if n<=0 { return DefaultMaxFrame }
+	return n
+}
+
+// Creates a Decoder. If r does not implement io.ByteReader, it will be wrapped into a bufio.Reader.
+func NewDecoder(r io.Reader) *Decoder {
+	pr,ok := r.(preciseio.Reader)
+	if !ok { pr = bufio.NewReader(r) }
+	return &Decoder{r:pr}
+}
+
+/*
+Decodes the next frame into the value pointed to by i. If i is nil, the frame is discarded.
+At the end of the stream, io.EOF is returned. Frames longer than MaxFrame are reported
+as EFrameTooLong. Otherwise the frame is consumed completely, even if decoding fails.
+*/
+func (dec *Decoder) Decode(i interface{}) (err error) {
+	dec.mutex.Lock()
+	defer dec.mutex.Unlock()
+	ln,e := binary.ReadUvarint(dec.r)
+	if e!=nil { return e }
+	if ln>uint64(frameLimit(dec.MaxFrame)) { return EFrameTooLong }
+	
+	// The value is decoded straight from the stream, so nothing is allocated up front.
+	br := &boundReader{dec.r,int(ln)}
+	defer func() {
+		_,e := io.CopyN(ioutil.Discard,br,int64(br.n))
+		if e==io.EOF { e = io.ErrUnexpectedEOF }
+		if err==nil { err = e }
+	}()
+	if i==nil { return nil }
+	if e = Unmarshal(preciseio.PreciseReader{R:br},i); e!=nil {
+		if e==io.EOF { e = io.ErrUnexpectedEOF }
+		return e
+	}
+	if br.n!=0 { return fmt.Errorf("quickdump: %d bytes left in frame",br.n) }
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package quickdump
+
+import "reflect"
+import "strconv"
+import "strings"
+import "sync"
+
+type tagOp uint8
+const (
+	opStrip tagOp = iota
+	opNullable
+)
+
+// The pre-parsed quickdump tag of a field.
+type fieldPlan struct{
+	name    string
+	index   int
+	ops     []tagOp
+	since   int    // quickdump:"since=N", 0 if not set.
+	compact bool   // quickdump:"compact"
+	unknown string // The first unknown tag, if any. Tags after it are ignored.
+}
+
+/*
+The compiled form of a structure type. Each group is either a single field,
+or the members of a Variant (quickdump:"tag" followed by quickdump:"more").
+*/
+type structPlan struct{
+	groups    [][]fieldPlan
+	versioned bool // Any field has a since=N tag.
+}
+
+var planCacheLock sync.RWMutex
+var planCache = make(map[reflect.Type]*structPlan)
+
+// Obtains the (cached) plan for the structure type t.
+func planFor(t reflect.Type) *structPlan {
+	planCacheLock.RLock()
+	p,ok := planCache[t]
+	planCacheLock.RUnlock()
+	if ok { return p }
+	p = compilePlan(t)
+	planCacheLock.Lock()
+	planCache[t] = p
+	planCacheLock.Unlock()
+	return p
+}
+
+func compilePlan(t reflect.Type) *structPlan {
+	p := new(structPlan)
+	n := t.NumField()
+	for i := 0 ; i<n ; {
+		incr := 1
+		if tag,_ := decodeTag2(t.Field(i)); tag=="tag" { incr = length(t,i,n) }
+		group := make([]fieldPlan,incr)
+		for j := range group {
+			group[j] = compileField(t.Field(i+j))
+		}
+		p.groups = append(p.groups,group)
+		if group[0].since!=0 { p.versioned = true }
+		i += incr
+	}
+	return p
+}
+
+func compileField(sf reflect.StructField) fieldPlan {
+	f := fieldPlan{name:sf.Name,index:sf.Index[0]}
+	tag,more := decodeTag2(sf)
+	if tag=="tag" || tag=="more" { tag,more = iterateString(more) }
+	for ; tag!="" ; tag,more = iterateString(more) {
+		switch tag {
+		case "strip": f.ops = append(f.ops,opStrip)
+		case "nullable": f.ops = append(f.ops,opNullable)
+		case "compact": f.compact = true
+		default:
+			if strings.HasPrefix(tag,"since=") {
+				if n,e := strconv.Atoi(tag[6:]); e==nil && n>0 {
+					f.since = n
+					continue
+				}
+			}
+			f.unknown = tag
+			return f
+		}
+	}
+	return f
+}
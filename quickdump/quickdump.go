@@ -28,6 +28,10 @@ import "github.com/byte-mug/golibs/preciseio"
 import "reflect"
 import "fmt"
 import "math"
+import "math/bits"
+import "bytes"
+import "sort"
+import "io"
 
 const ourTag = "quickdump"
 
@@ -67,7 +71,7 @@ func stripNullable(isR, isW bool, v reflect.Value) reflect.Value {
 	switch v.Kind() {
 	case reflect.Ptr: return elem(isR,isW,v)
 	case reflect.Struct:
-		v.Field(0).SetBool(true)
+		if isR { v.Field(0).SetBool(true) }
 		return v.Field(1)
 	}
 	panic(fmt.Sprint("This type is not nullable: ",v.Type()," of kind ",v.Kind()))
@@ -96,99 +100,126 @@ func length(t reflect.Type,i,n int) (l int) {
 	}
 	return
 }
-func findNonNULL(v reflect.Value,i,n int) (l int) {
-	l = 0
-	for ; i<n ; i++ {
-		if !nullableIsNULL(v.Field(i)) { return }
-		l++
+func findNonNULL(v reflect.Value,group []fieldPlan) int {
+	for i := range group {
+		if !nullableIsNULL(v.Field(group[i].index)) { return i }
 	}
-	l = -1
-	return
+	return -1
 }
 
-func vperformStruct(isR, isW bool, r preciseio.PreciseReader, w *preciseio.PreciseWriter, v reflect.Value) (err error) {
-	t := v.Type()
-	i := 0
-	n := t.NumField()
-	incr := 0
-	
-	//wasNULL := true
-	for ; i<n ; i+=incr {
-		tag,more := decodeTag2(t.Field(i))
-		fv := v.Field(i)
-		incr = 1
-		switch tag {
-		case "tag":
-			incr = length(t,i,n)
-			tag,more = iterateString(more)
-		
-		case "more": // Blind tags
-			tag,more = iterateString(more)
-		}
-		
+func vperformStruct(isR, isW bool, r preciseio.PreciseReader, w *preciseio.PreciseWriter, v reflect.Value) error {
+	p := planFor(v.Type())
+	if p.versioned { return vperformVersioned(isR,isW,r,w,v,p) }
+	for _,group := range p.groups {
+		e := vperformGroup(isR,isW,r,w,v,group)
+		if e!=nil { return e }
+	}
+	return nil
+}
+
+// Performs a single field, or a Variant.
+func vperformGroup(isR, isW bool, r preciseio.PreciseReader, w *preciseio.PreciseWriter, v reflect.Value, group []fieldPlan) (err error) {
+	f := &group[0]
+	if len(group)>1 {
 		idx := 0
-		if incr>1 {
+		if isR {
+			idx,err = r.ReadListLength()
+			if err!=nil { return }
+		}
+		if isW {
+			idx = findNonNULL(v,group)
+			if idx<0 { idx=len(group) }
+			err = w.WriteListLength(idx)
+			if err!=nil { return }
+		}
+		if idx>=len(group) { return nil }
+		f = &group[idx]
+	}
+	fv := v.Field(f.index)
+	
+	perform := true
+	for _,op := range f.ops {
+		if op==opNullable {
 			if isR {
-				idx,err = r.ReadListLength()
-				if err!=nil { return }
+				b,e := r.R.ReadByte()
+				if e!=nil { return e }
+				perform = b!=0
 			}
 			if isW {
-				idx = findNonNULL(v,i,i+incr)
-				if idx<0 { idx=incr }
-				err = w.WriteListLength(idx)
+				perform = !nullableIsNULL(fv)
+				b := byte(0)
+				if perform { b = 0xff }
+				err = w.W.WriteByte(b)
 				if err!=nil { return }
 			}
-			if idx<incr {
-				fv = v.Field(i+idx)
-				tag,more = decodeTag2(t.Field(i+idx))
-				tag,more = iterateString(more) // strip first tag.
-			} else {
-				continue
-			}
-		}
-		
-		perform := true
-		for ;; tag,more = iterateString(more) {
-			switch tag {
-			case "strip":
-				fv = stripNullable(isR,isW,fv)
-				continue
-			case "nullable":
-				if isR {
-					b,e := r.R.ReadByte()
-					if e!=nil { return e }
-					perform = b!=0
-				}
-				if isW {
-					perform = !nullableIsNULL(fv)
-					b := byte(0)
-					if perform { b = 0xff }
-					err = w.W.WriteByte(b)
-					if err!=nil { return }
-				}
-				if perform {
-					fv = stripNullable(isR,isW,fv)
-					continue
-				}
-			}
-			break
+			if !perform { return nil }
 		}
-		
-		if !perform { continue }
-		e := vperform(isR,isW,r,w,fv)
-		//wasNULL = isNULL(fv)
-		
+		fv = stripNullable(isR,isW,fv)
+	}
+	
+	if f.compact { return vperformCompact(isR,isW,r,w,fv) }
+	return vperform(isR,isW,r,w,fv)
+}
+
+// Performs a float field tagged quickdump:"compact": The IEEE 754 bits are byte-reversed (like encoding/gob).
+func vperformCompact(isR, isW bool, r preciseio.PreciseReader, w *preciseio.PreciseWriter, v reflect.Value) error {
+	if isR {
+		i,e := r.ReadUvarint()
 		if e!=nil { return e }
+		if v.Kind()==reflect.Float32 {
+			v.SetFloat(float64(math.Float32frombits(bits.ReverseBytes32(uint32(i)))))
+		} else {
+			v.SetFloat(math.Float64frombits(bits.ReverseBytes64(i)))
+		}
+		return nil
+	}
+	if isW {
+		if v.Kind()==reflect.Float32 { return w.WriteUvarint(uint64(bits.ReverseBytes32(math.Float32bits(float32(v.Float()))))) }
+		return w.WriteUvarint(bits.ReverseBytes64(math.Float64bits(v.Float())))
 	}
 	return nil
 }
 
+// Above this number of elements, slices are not pre-allocated at their full length,
+// so a forged length can not allocate huge amounts of memory.
+const maxPrealloc = 1<<12
+
+// Returns the number of slice elements, that may be pre-allocated. Within a frame or
+// a versioned structure, no more elements are pre-allocated than bytes are left.
+func preallocLimit(r preciseio.PreciseReader) int {
+	if br,ok := r.R.(*boundReader); ok && br.n<maxPrealloc { return br.n }
+	return maxPrealloc
+}
+
+// Like r.ReadBlob(), but within a frame or a versioned structure, the length is checked before allocating.
+func readBlob(r preciseio.PreciseReader) ([]byte,error) {
+	br,ok := r.R.(*boundReader)
+	if !ok { return r.ReadBlob() }
+	n,e := r.ReadListLength()
+	if e!=nil || n==0 { return nil,e }
+	if n>br.n { return nil,io.ErrUnexpectedEOF }
+	b := make([]byte,n)
+	_,e = io.ReadFull(br,b)
+	return b,e
+}
+
+// Returns the value pointed to by i, after checking its type.
+func target(i interface{}) (reflect.Value,error) {
+	v := reflect.ValueOf(i)
+	if v.Kind()!=reflect.Ptr || v.IsNil() { return v,fmt.Errorf("quickdump: required non-nil pointer, but got %T",i) }
+	v = v.Elem()
+	return v,checkType(v.Type())
+}
+
 func Marshal(w *preciseio.PreciseWriter,i interface{}) error {
-	return vperform(false,true,preciseio.PreciseReader{},w,reflect.ValueOf(i).Elem())
+	v,e := target(i)
+	if e!=nil { return e }
+	return vperform(false,true,preciseio.PreciseReader{},w,v)
 }
 
 func Unmarshal(r preciseio.PreciseReader,i interface{}) error {
-	v := reflect.ValueOf(i).Elem()
+	v,e := target(i)
+	if e!=nil { return e }
 	v.Set(reflect.Zero(v.Type()))
 	return vperform(true,false,r,nil,v)
 }
@@ -319,7 +350,7 @@ func vperform(isR, isW bool, r preciseio.PreciseReader, w *preciseio.PreciseWrit
 	case reflect.Slice:
 		if v.Type().Elem().Kind()==reflect.Uint8 {
 			if isR {
-				blob,e := r.ReadBlob()
+				blob,e := readBlob(r)
 				v.SetBytes(blob)
 				return e
 			}
@@ -332,6 +363,18 @@ func vperform(isR, isW bool, r preciseio.PreciseReader, w *preciseio.PreciseWrit
 		if isR {
 			n,e := r.ReadListLength()
 			if e!=nil { return e }
+			if n>preallocLimit(r) {
+				// Don't trust n: grow the slice as elements arrive.
+				nv := reflect.MakeSlice(v.Type(),0,preallocLimit(r))
+				ez := reflect.Zero(v.Type().Elem())
+				for i:=0 ; i<n ; i++ {
+					nv = reflect.Append(nv,ez)
+					e = vperform(isR, isW, r, w, nv.Index(i))
+					if e!=nil { return e }
+				}
+				v.Set(nv)
+				return nil
+			}
 			nv := reflect.MakeSlice(v.Type(),n,n)
 			v.Set(nv)
 			for i:=0 ; i<n ; i++ {
@@ -360,10 +403,14 @@ func vperform(isR, isW bool, r preciseio.PreciseReader, w *preciseio.PreciseWrit
 		return nil
 	case reflect.Struct:
 		return vperformStruct(isR, isW, r, w, v)
+	case reflect.Interface:
+		return vperformInterface(isR, isW, r, w, v)
+	case reflect.Map:
+		return vperformMap(isR, isW, r, w, v)
 	case reflect.String:
 		{
 			if isR {
-				blob,e := r.ReadBlob()
+				blob,e := readBlob(r)
 				v.SetString(string(blob))
 				return e
 			}
@@ -393,8 +440,71 @@ func vperform(isR, isW bool, r preciseio.PreciseReader, w *preciseio.PreciseWrit
 		}
 		return nil
 	}
-	panic(fmt.Sprint("unsupported type: ",v.Type()," of kind ",v.Kind()))
+	return &TypeError{v.Type().String(),v.Type(),"unsupported type"}
 }
 
-
-
+/*
+Maps are encoded like pointers: 0 for nil, otherwise 0xff followed by the number of entries
+and the entries. The entries are sorted by their encoded keys, so the encoding is deterministic.
+*/
+func vperformMap(isR, isW bool, r preciseio.PreciseReader, w *preciseio.PreciseWriter, v reflect.Value) error {
+	t := v.Type()
+	if isR {
+		b,e := r.R.ReadByte()
+		if e!=nil { return e }
+		if b==0 {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		n,e := r.ReadListLength()
+		if e!=nil { return e }
+		nv := reflect.MakeMap(t)
+		for i:=0 ; i<n ; i++ {
+			kv := reflect.New(t.Key()).Elem()
+			ev := reflect.New(t.Elem()).Elem()
+			if e = vperform(isR, isW, r, w, kv); e!=nil { return e }
+			if e = vperform(isR, isW, r, w, ev); e!=nil { return e }
+			nv.SetMapIndex(kv,ev)
+		}
+		v.Set(nv)
+		return nil
+	}
+	if isW {
+		if v.IsNil() { return w.W.WriteByte(0) }
+		e := w.W.WriteByte(0xff)
+		if e!=nil { return e }
+		// Collect keys and values together: MapIndex() can't find NaN keys.
+		n := v.Len()
+		keys := make([]reflect.Value,0,n)
+		vals := make([]reflect.Value,0,n)
+		for it := v.MapRange() ; it.Next() ; {
+			keys = append(keys,it.Key())
+			vals = append(vals,it.Value())
+		}
+		e = w.WriteListLength(n)
+		if e!=nil { return e }
+		
+		buf := new(bytes.Buffer)
+		kw := preciseio.PreciseWriterFromPool()
+		defer kw.PutToPool()
+		kw.W = buf
+		offs := make([]int,n+1)
+		for i,key := range keys {
+			if e = vperform(isR, isW, r, kw, key); e!=nil { return e }
+			offs[i+1] = buf.Len()
+		}
+		kbuf := buf.Bytes()
+		idx := make([]int,n)
+		for i := range idx { idx[i] = i }
+		sort.Slice(idx,func(i,j int) bool {
+			a,b := idx[i],idx[j]
+			return bytes.Compare(kbuf[offs[a]:offs[a+1]],kbuf[offs[b]:offs[b+1]])<0
+		})
+		for _,i := range idx {
+			if _,e = w.W.Write(kbuf[offs[i]:offs[i+1]]); e!=nil { return e }
+			if e = vperform(isR, isW, r, w, vals[i]); e!=nil { return e }
+		}
+		return nil
+	}
+	return nil
+}
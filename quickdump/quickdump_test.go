@@ -0,0 +1,474 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package quickdump
+
+import "github.com/byte-mug/golibs/preciseio"
+import "bufio"
+import "bytes"
+import "io"
+import "io/ioutil"
+import "math"
+import "reflect"
+import "runtime"
+import "strings"
+import "testing"
+
+type benchOptional struct{
+	Ok   bool
+	Data uint32
+}
+
+type benchAlpha struct{
+	Name  string
+	Value int64
+}
+
+type benchBeta struct{
+	Items []uint16
+}
+
+type benchMessage struct{
+	ID       uint64
+	Kind     int8
+	Flags    [4]bool
+	Title    string
+	Payload  []byte
+	Ratio    float64
+	Parent   *benchMessage
+	Optional benchOptional `quickdump:"nullable"`
+	Alpha    *benchAlpha   `quickdump:"tag,strip"`
+	Beta     *benchBeta    `quickdump:"more,strip"`
+	Children []benchAlpha
+}
+
+func benchValue() *benchMessage {
+	return &benchMessage{
+		ID:12345,Kind:-2,Flags:[4]bool{true,false,true,false},
+		Title:"benchmark message",Payload:make([]byte,64),Ratio:0.25,
+		Parent:&benchMessage{ID:1,Title:"parent"},
+		Optional:benchOptional{true,42},
+		Beta:&benchBeta{[]uint16{1,2,3,4}},
+		Children:[]benchAlpha{{"a",1},{"b",2},{"c",3}},
+	}
+}
+
+func benchEncode(b *testing.B) []byte {
+	var buf bytes.Buffer
+	w := &preciseio.PreciseWriter{W:&buf}
+	w.Initialize()
+	if err := Marshal(w,benchValue()); err!=nil { b.Fatal(err) }
+	return buf.Bytes()
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	v := benchValue()
+	w := &preciseio.PreciseWriter{W:discardWriter{}}
+	w.Initialize()
+	b.SetBytes(int64(len(benchEncode(b))))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0 ; i<b.N ; i++ {
+		if err := Marshal(w,v); err!=nil { b.Fatal(err) }
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	data := benchEncode(b)
+	rd := bytes.NewReader(data)
+	var v benchMessage
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0 ; i<b.N ; i++ {
+		rd.Reset(data)
+		if err := Unmarshal(preciseio.PreciseReader{R:rd},&v); err!=nil { b.Fatal(err) }
+	}
+}
+
+type discardWriter struct{}
+
+func (discardWriter) Write(p []byte) (int,error) { return ioutil.Discard.Write(p) }
+func (discardWriter) WriteByte(c byte) error      { return nil }
+
+type unexportedField struct{
+	A int
+	b int
+}
+
+type strippedString struct{
+	S string `quickdump:"strip"`
+}
+
+type unknownTag struct{
+	A int `quickdump:"bogus"`
+}
+
+type misorderedSince struct{
+	A int `quickdump:"since=2"`
+	B int `quickdump:"since=1"`
+}
+
+type nestedBad struct{
+	Inner []map[string]*unexportedField
+}
+
+func typeErrorPath(err error) string {
+	if te,ok := err.(*TypeError); ok { return te.Path }
+	return ""
+}
+
+func TestTypeErrors(t *testing.T) {
+	cases := []struct{
+		name string
+		v    interface{}
+		path string
+	}{
+		{"unexported",&unexportedField{},"quickdump.unexportedField.b"},
+		{"strip on string",&strippedString{},"quickdump.strippedString.S"},
+		{"unknown tag",&unknownTag{},"quickdump.unknownTag.A"},
+		{"since order",&misorderedSince{},"quickdump.misorderedSince.B"},
+		{"nested",&nestedBad{},"quickdump.nestedBad.Inner[][].b"},
+		{"func",new(func()),"func()"},
+		{"chan",&struct{ C chan int }{},"struct { C chan int }.C"},
+	}
+	for _,c := range cases {
+		// Both directions reject the type.
+		err := Marshal(&preciseio.PreciseWriter{W:discardWriter{}},c.v)
+		if p := typeErrorPath(err); p!=c.path { t.Errorf("%s: Marshal: got %v, want a TypeError at %s",c.name,err,c.path) }
+		err = Unmarshal(preciseio.PreciseReader{R:bytes.NewReader(make([]byte,16))},c.v)
+		if p := typeErrorPath(err); p!=c.path { t.Errorf("%s: Unmarshal: got %v, want a TypeError at %s",c.name,err,c.path) }
+	}
+}
+
+func TestTargets(t *testing.T) {
+	var v benchAlpha
+	cases := []struct{
+		name string
+		i    interface{}
+	}{
+		{"nil",nil},
+		{"nil pointer",(*benchAlpha)(nil)},
+		{"non-pointer",v},
+	}
+	for _,c := range cases {
+		if err := Marshal(&preciseio.PreciseWriter{W:discardWriter{}},c.i); err==nil { t.Errorf("%s: Marshal succeeded",c.name) }
+		if err := Unmarshal(preciseio.PreciseReader{R:bytes.NewReader(nil)},c.i); err==nil { t.Errorf("%s: Unmarshal succeeded",c.name) }
+	}
+}
+
+type floats struct{
+	F  float64
+	G  float32
+	CF float64 `quickdump:"compact"`
+	CG float32 `quickdump:"compact"`
+}
+
+func TestFloatEncoding(t *testing.T) {
+	// F and G use the original encoding, so data written by earlier versions still decodes.
+	data := []byte{
+		0x80,0x80,0x80,0x80,0x80,0x80,0x80,0xf8,0x3f, // F = 1.0
+		0x80,0x80,0x80,0xf8,0x03, // G = 0.5
+		0xbf,0xe0,0x03, // CF = 1.0, byte-reversed
+		0x3f, // CG = 0.5, byte-reversed
+	}
+	var v floats
+	if err := Unmarshal(preciseio.PreciseReader{R:bytes.NewReader(data)},&v); err!=nil { t.Fatal(err) }
+	if want := (floats{1,0.5,1,0.5}); v!=want { t.Errorf("got %+v, want %+v",v,want) }
+	var buf bytes.Buffer
+	w := &preciseio.PreciseWriter{W:&buf}
+	w.Initialize()
+	if err := Marshal(w,&v); err!=nil { t.Fatal(err) }
+	if !bytes.Equal(buf.Bytes(),data) { t.Errorf("Marshal = %x, want %x",buf.Bytes(),data) }
+
+	err := Marshal(&preciseio.PreciseWriter{W:discardWriter{}},&struct{
+		C complex128 `quickdump:"compact"`
+	}{})
+	if _,ok := err.(*TypeError); !ok { t.Errorf("compact complex128: got %v, want a TypeError",err) }
+}
+
+type recordV1 struct{
+	ID   uint64 `quickdump:"since=1"`
+	Name string
+}
+
+type recordV2 struct{
+	ID    uint64 `quickdump:"since=1"`
+	Name  string
+	Email string   `quickdump:"since=2"`
+	Tags  []string `quickdump:"since=2"`
+	Blob  []byte   `quickdump:"since=3"`
+}
+
+// The ID of recordV1, but not its untagged Name.
+type recordIDOnly struct{
+	ID uint64 `quickdump:"since=1"`
+}
+
+func TestVersioned(t *testing.T) {
+	v2 := recordV2{7,"seven","e@x",[]string{"a"},[]byte{1}}
+	cases := []struct{
+		name string
+		in   interface{} // A value to marshal, or the encoded bytes.
+		out  interface{} // A pointer to the zero value of the decoded type.
+		want interface{}
+		err  string
+	}{
+		{"old->new",&recordV1{1,"one"},new(recordV2),&recordV2{ID:1,Name:"one"},""},
+		{"new->old",&v2,new(recordV1),&recordV1{7,"seven"},""},
+		{"same",&v2,new(recordV2),&v2,""},
+		{"since=1 missing",[]byte{0},new(recordV1),nil,"recordV1.ID is missing"},
+		{"untagged missing",&recordIDOnly{1},new(recordV1),nil,"recordV1.Name is missing"},
+	}
+	for _,c := range cases {
+		data,ok := c.in.([]byte)
+		if !ok {
+			var err error
+			if data,err = MarshalBytes(c.in); err!=nil { t.Fatalf("%s: %v",c.name,err) }
+		}
+		data = append(data,0xee)
+		rd := bytes.NewReader(data)
+		err := Unmarshal(preciseio.PreciseReader{R:rd},c.out)
+		if c.err!="" {
+			if err==nil || !strings.Contains(err.Error(),c.err) { t.Errorf("%s: got %v, want %q",c.name,err,c.err) }
+			continue
+		}
+		if err!=nil {
+			t.Errorf("%s: %v",c.name,err)
+		} else if !reflect.DeepEqual(c.out,c.want) {
+			t.Errorf("%s: got %+v, want %+v",c.name,c.out,c.want)
+		} else if rd.Len()!=1 {
+			t.Errorf("%s: %d bytes left, want 1",c.name,rd.Len())
+		}
+	}
+}
+
+func TestVersionedTooLong(t *testing.T) {
+	v := &recordV2{Blob:make([]byte,1<<24)}
+	if _,err := MarshalBytes(v); err!=preciseio.EBlobTooLong { t.Errorf("got %v, want EBlobTooLong",err) }
+	v.Blob = v.Blob[:1<<23]
+	data,err := MarshalBytes(v)
+	if err!=nil { t.Fatal(err) }
+	var out recordV2
+	if err = UnmarshalBytes(data,&out); err!=nil || len(out.Blob)!=1<<23 { t.Errorf("got %d bytes, %v",len(out.Blob),err) }
+}
+
+func TestFraming(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _,v := range []*benchAlpha{{"a",1},{"b",2},{"c",3}} {
+		if err := enc.Encode(v); err!=nil { t.Fatal(err) }
+	}
+	if err := enc.Encode(&benchBeta{[]uint16{1}}); err!=nil { t.Fatal(err) }
+	dec := NewDecoder(&buf)
+	var v benchAlpha
+	if err := dec.Decode(&v); err!=nil || v!=(benchAlpha{"a",1}) { t.Errorf("first frame: got %+v, %v",v,err) }
+	if err := dec.Decode(nil); err!=nil { t.Errorf("skipping: %v",err) }
+	if err := dec.Decode(&v); err!=nil || v!=(benchAlpha{"c",3}) { t.Errorf("third frame: got %+v, %v",v,err) }
+	// benchBeta is shorter than benchAlpha, so decoding it as benchAlpha fails.
+	if err := dec.Decode(&v); err!=io.ErrUnexpectedEOF { t.Errorf("mismatched frame: got %v, want io.ErrUnexpectedEOF",err) }
+	if err := dec.Decode(&v); err!=io.EOF { t.Errorf("end of stream: got %v, want io.EOF",err) }
+}
+
+func TestFramingErrors(t *testing.T) {
+	alpha,_ := MarshalBytes(&benchAlpha{"a",1})
+	frame := append([]byte{byte(len(alpha))},alpha...)
+	cases := []struct{
+		name string
+		data []byte
+		max  int
+		err  string
+	}{
+		{"truncated",frame[:len(frame)-1],0,"unexpected EOF"},
+		{"truncated length",[]byte{0x80},0,"unexpected EOF"},
+		{"leftover",append([]byte{byte(len(alpha)+2)},append(alpha,0,0)...),0,"2 bytes left in frame"},
+		{"limit",frame,len(alpha)-1,EFrameTooLong.Error()},
+		{"default limit",[]byte{0x81,0x80,0x80,0x80,0x04},0,EFrameTooLong.Error()},
+		{"huge",[]byte{0x80,0x80,0x80,0x80,0x04,1},0,"unexpected EOF"},
+	}
+	for _,c := range cases {
+		dec := NewDecoder(bytes.NewReader(c.data))
+		dec.MaxFrame = c.max
+		var v benchAlpha
+		if err := dec.Decode(&v); err==nil || !strings.Contains(err.Error(),c.err) { t.Errorf("%s: got %v, want %q",c.name,err,c.err) }
+	}
+
+	// Forged lengths don't allocate more than the frame can hold.
+	forged := []struct{
+		name string
+		data []byte
+		v    interface{}
+	}{
+		{"frame",[]byte{0x80,0x80,0x80,0x80,0x04,1},nil},
+		{"slice",[]byte{4,0xff,0xff,0xff,0x01},new([][8]uint64)},
+		{"blob",[]byte{4,0xff,0xff,0xff,0x01},new([]byte)},
+		{"string",[]byte{4,0xff,0xff,0xff,0x01},new(string)},
+		{"interface",[]byte{4,0xff,0xff,0xff,0x01},new(interface{})},
+	}
+	for _,c := range forged {
+		dec := NewDecoder(bytes.NewReader(c.data))
+		dec.MaxFrame = 16
+		if c.v==nil { dec.MaxFrame = 0 }
+		var before,after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := dec.Decode(c.v)
+		runtime.ReadMemStats(&after)
+		if err!=io.ErrUnexpectedEOF { t.Errorf("forged %s: got %v, want io.ErrUnexpectedEOF",c.name,err) }
+		if n := after.TotalAlloc-before.TotalAlloc; n>1<<16 { t.Errorf("forged %s: allocated %d bytes",c.name,n) }
+	}
+
+	// A frame, that failed to decode, is consumed, so the next one can be read.
+	data := append(append([]byte{byte(len(alpha)+2)},append(alpha,0,0)...),frame...)
+	dec := NewDecoder(bytes.NewReader(data))
+	var v benchAlpha
+	dec.Decode(&v)
+	if err := dec.Decode(&v); err!=nil || v!=(benchAlpha{"a",1}) { t.Errorf("after leftover: got %+v, %v",v,err) }
+
+	enc := NewEncoder(ioutil.Discard)
+	enc.MaxFrame = 2
+	if err := enc.Encode(&benchAlpha{"a",1}); err!=EFrameTooLong { t.Errorf("Encode: got %v, want EFrameTooLong",err) }
+}
+
+func TestEncodeFlushes(t *testing.T) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	if err := Encode(bw,&benchAlpha{"a",1}); err!=nil { t.Fatal(err) }
+	var v benchAlpha
+	if err := Decode(&buf,&v); err!=nil || v!=(benchAlpha{"a",1}) { t.Errorf("got %+v, %v",v,err) }
+}
+
+type testShape interface{ Area() int }
+type testSquare struct{ Side int }
+type testRect struct{ W,H int }
+type testCircle struct{ R int }
+
+func (s testSquare) Area() int { return s.Side*s.Side }
+func (r *testRect) Area() int { return r.W*r.H }
+func (c testCircle) Area() int { return 3*c.R*c.R }
+
+func init() {
+	Register("quickdump.testSquare",testSquare{})
+	Register("quickdump.testRect",&testRect{})
+	Register("quickdump.benchAlpha",benchAlpha{})
+}
+
+type drawing struct{
+	Shapes []testShape
+	Main   testShape
+	Any    interface{}
+}
+
+func TestInterfaces(t *testing.T) {
+	in := &drawing{[]testShape{testSquare{2},&testRect{2,3},nil},testSquare{1},benchAlpha{"x",1}}
+	data,err := MarshalBytes(in)
+	if err!=nil { t.Fatal(err) }
+	var out drawing
+	if err = UnmarshalBytes(data,&out); err!=nil { t.Fatal(err) }
+	if !reflect.DeepEqual(&out,in) { t.Errorf("got %+v, want %+v",out,in) }
+
+	// The name of a registered type, that does not implement testShape.
+	bad := append([]byte{1,20},"quickdump.benchAlpha"...)
+	cases := []struct{
+		name string
+		v    interface{}
+		data []byte
+		err  string
+	}{
+		{"unregistered",&drawing{Main:testCircle{1}},nil,"type quickdump.testCircle is not registered"},
+		{"unregistered any",&drawing{Any:1},nil,"type int is not registered"},
+		{"unknown name",nil,append([]byte{1,8},"circle1."...),`unknown type name "circle1."`},
+		{"wrong type",nil,append(bad,1,'x',2),"does not implement"},
+	}
+	for _,c := range cases {
+		if c.v!=nil {
+			_,err = MarshalBytes(c.v)
+		} else {
+			err = UnmarshalBytes(c.data,new(drawing))
+		}
+		if err==nil || !strings.Contains(err.Error(),c.err) { t.Errorf("%s: got %v, want %q",c.name,err,c.err) }
+	}
+}
+
+type maps struct{
+	M map[string]int
+	N map[int][]string
+}
+
+func TestMaps(t *testing.T) {
+	cases := []struct{
+		name string
+		v    maps
+	}{
+		{"nil",maps{}},
+		{"empty",maps{map[string]int{},map[int][]string{}}},
+		{"entries",maps{map[string]int{"a":1,"b":2,"":0},map[int][]string{-1:{"y"},1:{"x"},300:{}}}},
+	}
+	for _,c := range cases {
+		data,err := MarshalBytes(&c.v)
+		if err!=nil { t.Fatal(err) }
+		var out maps
+		if err = UnmarshalBytes(data,&out); err!=nil { t.Fatalf("%s: %v",c.name,err) }
+		// DeepEqual tells nil and empty maps apart.
+		if !reflect.DeepEqual(out,c.v) { t.Errorf("%s: got %#v, want %#v",c.name,out,c.v) }
+	}
+
+	// Equal maps encode identically, regardless of the insertion and iteration order.
+	m := make(map[string]int)
+	for i := 0 ; i<100 ; i++ {
+		m[strings.Repeat("k",i%10)+string(rune('a'+i%26))] = i
+	}
+	first,_ := MarshalBytes(&maps{M:m})
+	for i := 0 ; i<20 ; i++ {
+		c := make(map[string]int)
+		for k,v := range m {
+			c[k] = v
+		}
+		if data,_ := MarshalBytes(&maps{M:c}); !bytes.Equal(data,first) { t.Fatal("map encoding is not deterministic") }
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	// Variants, nullables and nested pointers, as compiled into the cached plans.
+	cases := []*benchMessage{benchValue(),{},{Alpha:&benchAlpha{"a",1}}}
+	for i,in := range cases {
+		data,err := MarshalBytes(in)
+		if err!=nil { t.Fatal(err) }
+		var out benchMessage
+		if err = UnmarshalBytes(data,&out); err!=nil { t.Fatalf("%d: %v",i,err) }
+		again,_ := MarshalBytes(&out)
+		if !bytes.Equal(again,data) { t.Errorf("%d: got %+v, want %+v",i,out,*in) }
+	}
+}
+
+func TestMapNaNKeys(t *testing.T) {
+	in := map[float64]int{math.NaN():1,2:3}
+	data,err := MarshalBytes(&in)
+	if err!=nil { t.Fatal(err) }
+	var out map[float64]int
+	if err = UnmarshalBytes(data,&out); err!=nil { t.Fatal(err) }
+	for k,v := range out {
+		if k==k && (k!=2 || v!=3) || k!=k && v!=1 { t.Errorf("unexpected entry %v: %v",k,v) }
+	}
+	if len(out)!=2 { t.Errorf("got %v",out) }
+}
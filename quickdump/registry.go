@@ -0,0 +1,102 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package quickdump
+
+import "github.com/byte-mug/golibs/preciseio"
+import "reflect"
+import "fmt"
+import "sync"
+
+var registryLock sync.RWMutex
+var registryByName = make(map[string]reflect.Type)
+var registryByType = make(map[reflect.Type]string)
+
+/*
+Registers the type of sample under the given name, so that it can be stored in interface values.
+Like gob.Register(), this is typically called from an init() function:
+
+	func init() {
+		quickdump.Register("main.Circle",Circle{})
+		quickdump.Register("main.Square",&Square{})
+	}
+
+A type and a name can only be registered once. The name must not be empty.
+*/
+func Register(name string, sample interface{}) {
+	t := reflect.TypeOf(sample)
+	if name=="" { panic("quickdump: the empty name is reserved for nil") }
+	if t==nil { panic("quickdump: can not register <nil>") }
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if ot,ok := registryByName[name]; ok { panic(fmt.Sprintf("quickdump: name %q is already registered for %v",name,ot)) }
+	if on,ok := registryByType[t]; ok { panic(fmt.Sprintf("quickdump: type %v is already registered as %q",t,on)) }
+	registryByName[name] = t
+	registryByType[t] = name
+}
+
+func registeredType(name string) reflect.Type {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return registryByName[name]
+}
+func registeredName(t reflect.Type) (string,bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	n,ok := registryByType[t]
+	return n,ok
+}
+
+// Interface values are encoded as the registered name (as blob) followed by the value.
+// A nil interface is encoded as the empty name.
+func vperformInterface(isR, isW bool, r preciseio.PreciseReader, w *preciseio.PreciseWriter, v reflect.Value) error {
+	if isR {
+		name,e := readBlob(r)
+		if e!=nil { return e }
+		if len(name)==0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		t := registeredType(string(name))
+		if t==nil { return fmt.Errorf("quickdump: unknown type name %q",name) }
+		if !t.AssignableTo(v.Type()) { return fmt.Errorf("quickdump: type %v (%q) does not implement %v",t,name,v.Type()) }
+		if e = checkType(t); e!=nil { return e }
+		nv := reflect.New(t).Elem()
+		e = vperform(isR,isW,r,w,nv)
+		if e!=nil { return e }
+		v.Set(nv)
+		return nil
+	}
+	if isW {
+		if v.IsNil() { return w.WriteBlob(nil) }
+		ev := v.Elem()
+		name,ok := registeredName(ev.Type())
+		if !ok { return fmt.Errorf("quickdump: type %v is not registered",ev.Type()) }
+		if e := checkType(ev.Type()); e!=nil { return e }
+		e := w.WriteBlob([]byte(name))
+		if e!=nil { return e }
+		return vperform(isR,isW,r,w,ev)
+	}
+	return nil
+}
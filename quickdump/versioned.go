@@ -0,0 +1,90 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package quickdump
+
+import "github.com/byte-mug/golibs/preciseio"
+import "reflect"
+import "bytes"
+import "fmt"
+import "io"
+import "io/ioutil"
+
+// Limits a Reader to the remaining n bytes of a versioned structure.
+type boundReader struct{
+	r preciseio.Reader
+	n int
+}
+func (b *boundReader) Read(p []byte) (int, error) {
+	if b.n<=0 { return 0,io.EOF }
+	if len(p)>b.n { p = p[:b.n] }
+	n,e := b.r.Read(p)
+	b.n -= n
+	return n,e
+}
+func (b *boundReader) ReadByte() (byte, error) {
+	if b.n<=0 { return 0,io.EOF }
+	c,e := b.r.ReadByte()
+	if e==nil { b.n-- }
+	return c,e
+}
+
+/*
+Performs a structure containing since=N fields. The fields are written as a
+single blob, so that readers can tell where the structure ends: since=N fields
+(N>=2), that are missing at the end are left zero, unknown trailing fields are skipped.
+Fields of the first version (untagged or since=1) are always present.
+*/
+func vperformVersioned(isR, isW bool, r preciseio.PreciseReader, w *preciseio.PreciseWriter, v reflect.Value, p *structPlan) error {
+	if isR {
+		n,e := r.ReadListLength()
+		if e!=nil { return e }
+		br := &boundReader{r.R,n}
+		sr := preciseio.PreciseReader{R:br}
+		for _,group := range p.groups {
+			if br.n==0 {
+				if group[0].since<=1 { return fmt.Errorf("quickdump: %v.%s is missing",v.Type(),group[0].name) }
+				for _,f := range group { v.Field(f.index).Set(reflect.Zero(v.Type().Field(f.index).Type)) }
+				continue
+			}
+			e = vperformGroup(isR,isW,sr,w,v,group)
+			if e==io.EOF { e = io.ErrUnexpectedEOF }
+			if e!=nil { return e }
+		}
+		_,e = io.CopyN(ioutil.Discard,br,int64(br.n))
+		if e==io.EOF { e = io.ErrUnexpectedEOF }
+		return e
+	}
+	if isW {
+		buf := new(bytes.Buffer)
+		fw := preciseio.PreciseWriterFromPool()
+		defer fw.PutToPool()
+		fw.W = buf
+		for _,group := range p.groups {
+			if e := vperformGroup(isR,isW,r,fw,v,group); e!=nil { return e }
+		}
+		return w.WriteBlob(buf.Bytes())
+	}
+	return nil
+}
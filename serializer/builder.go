@@ -38,11 +38,14 @@ func ForTypeP(i interface{}) CodecElement {
 }
 
 func serializerFor(t reflect.Type) CodecElement {
+	return serializerForIn(t,nil)
+}
+func serializerForIn(t reflect.Type, ctx *structCtx) CodecElement {
 	switch t.Kind() {
 	case reflect.Slice:
 		se := t.Elem()
 		if se.Kind()==reflect.Uint8 { return ceBlob{} }
-		r := serializerFor(se)
+		r := serializerForIn(se,ctx)
 		if r==nil { return nil }
 		return ceSlice{r,t}
 	case reflect.String:
@@ -55,24 +58,41 @@ func serializerFor(t reflect.Type) CodecElement {
 		return ceSbyte{}
 	case reflect.Uint8: // 8-bit unsigned integer = special case
 		return ceByte{}
+	case reflect.Bool:
+		return ceBool{}
+	case reflect.Float32:
+		return ceFloat32{}
+	case reflect.Float64:
+		return ceFloat64{}
+	case reflect.Complex64:
+		return ceComplex64{}
+	case reflect.Complex128:
+		return ceComplex128{}
 	case reflect.Map:
 		mk := t.Key()
 		me := t.Elem()
-		mks := serializerFor(mk)
+		mks := serializerForIn(mk,ctx)
 		if mks==nil { return nil }
-		mes := serializerFor(me)
+		mes := serializerForIn(me,ctx)
 		if mes==nil { return nil }
 		return ceMap{mks,mes,t}
 	case reflect.Array:
 		ae := t.Elem()
-		aes := serializerFor(ae)
+		aes := serializerForIn(ae,ctx)
 		if aes==nil { return nil }
 		return ceArray{aes,t}
 	case reflect.Ptr:
 		pe := t.Elem()
-		pes := serializerFor(pe)
+		pes := serializerForIn(pe,ctx)
 		if pes==nil { return nil }
 		return cePtr{pes,t}
+	case reflect.Struct:
+		if t==tpTime { return ceTime{} }
+		sb,_ := structCodec(t,ctx)
+		if sb==nil { return nil }
+		return sb
+	case reflect.Interface:
+		if r := boundRegistry(t); r!=nil { return r }
 	}
 	return nil
 }
@@ -132,34 +152,62 @@ func serializerForElem(t reflect.Type,depth int, contained CodecElement) CodecEl
 type strctField struct{
 	ce   CodecElement
 	idxs []int
+	id   uint64 // only used by versioned structs
 }
 type StructBuilder struct{
 	t reflect.Type
 	fields []strctField
 	noptr bool
+	versioned bool
+	byID map[uint64]int
+	untagged string // The first field without a serializer:"N" tag.
 }
 func With(i interface{}) *StructBuilder {
 	ti := reflect.TypeOf(i)
 	if ti.Kind()!=reflect.Ptr || ti.Elem().Kind()!=reflect.Struct { panic(fmt.Sprintf("Required *struct{}, but got %v",ti)) }
-	return &StructBuilder{ti,nil,false}
+	return &StructBuilder{t:ti}
 }
 func WithInline(i interface{}) *StructBuilder {
 	ti := reflect.TypeOf(i)
 	if ti.Kind()!=reflect.Ptr || ti.Elem().Kind()!=reflect.Struct { panic(fmt.Sprintf("Required *struct{}, but got %v",ti)) }
-	return &StructBuilder{ti,nil,true}
+	return &StructBuilder{t:ti,noptr:true}
+}
+func (s *StructBuilder) withPtr() *StructBuilder {
+	ns := *s
+	ns.noptr = false
+	return &ns
+}
+func (s *StructBuilder) addField(id uint64,ce CodecElement,idxs []int) {
+	s.fields = append(s.fields,strctField{ce,idxs,id})
+	if s.versioned { s.indexField(len(s.fields)-1) }
+}
+// Makes the i-th field known by its field-ID. Only versioned structures have IDs.
+func (s *StructBuilder) indexField(i int) {
+	id := s.fields[i].id
+	if s.byID==nil { s.byID = make(map[uint64]int) }
+	if _,ok := s.byID[id]; ok { panic(fmt.Sprintf("Field-ID %d is already in use",id)) }
+	s.byID[id] = i
+}
+func (s *StructBuilder) add(f reflect.StructField,ce CodecElement) {
+	id,ok := tagOrdinal(f)
+	if !ok {
+		if s.versioned { panic(fmt.Sprintf("Field %s: versioned structures require a serializer:\"N\" tag",f.Name)) }
+		if s.untagged=="" { s.untagged = f.Name }
+	}
+	s.addField(id,ce,f.Index)
 }
 func (s *StructBuilder) Field(name string) *StructBuilder{
 	f,ok := s.t.Elem().FieldByName(name)
 	if !ok { panic("No such field "+name) }
 	ser := serializerFor(f.Type)
 	if ser==nil { panic(fmt.Sprintf("Field %s: non-supported type: %v",name,f.Type)) }
-	s.fields = append(s.fields,strctField{ser,f.Index})
+	s.add(f,ser)
 	return s
 }
 func (s *StructBuilder) FieldWith(name string,ce CodecElement) *StructBuilder{
 	f,ok := s.t.Elem().FieldByName(name)
 	if !ok { panic("No such field "+name) }
-	s.fields = append(s.fields,strctField{ce,f.Index})
+	s.add(f,ce)
 	return s
 }
 func (s *StructBuilder) FieldContainerWithDepth(name string,depth int,ce CodecElement) *StructBuilder{
@@ -168,7 +216,7 @@ func (s *StructBuilder) FieldContainerWithDepth(name string,depth int,ce CodecEl
 	if !ok { panic("No such field "+name) }
 	ser := serializerForElem(f.Type,depth,ce)
 	if ser==nil { panic(fmt.Sprintf("Field %s: non-supported type: [depth=%d] %v",name,depth,f.Type)) }
-	s.fields = append(s.fields,strctField{ser,f.Index})
+	s.add(f,ser)
 	return s
 }
 func (s *StructBuilder) FieldContainerWith(name string,ce CodecElement) *StructBuilder{
@@ -177,10 +225,13 @@ func (s *StructBuilder) FieldContainerWith(name string,ce CodecElement) *StructB
 	if !ok { panic("No such field "+name) }
 	ser := serializerForElem(f.Type,1,ce)
 	if ser==nil { panic(fmt.Sprintf("Field %s: non-supported type: [depth=1] %v",name,f.Type)) }
-	s.fields = append(s.fields,strctField{ser,f.Index})
+	s.add(f,ser)
 	return s
 }
 func (s *StructBuilder) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	l,e := enter(r)
+	if e!=nil { return e }
+	defer l.leave()
 	if s.noptr {
 		return s.directRead(r,v)
 	}
@@ -193,23 +244,23 @@ func (s *StructBuilder) ptrRead(r preciseio.PreciseReader,v reflect.Value) error
 		v.Set(reflect.Zero(v.Type()))
 		return nil
 	}
+	if limitsOf(r).reusing() && v.Type()==s.t && !v.IsNil() { return s.readFields(r,v.Elem()) }
 	pv := reflect.New(s.t.Elem())
 	defer v.Set(pv)
-	ev := pv.Elem()
-	for _,field := range s.fields {
-		e = field.ce.Read(r,ev.FieldByIndex(field.idxs))
-		if e!=nil { return e }
-	}
-	return nil
+	return s.readFields(r,pv.Elem())
 }
 func (s *StructBuilder) directRead(r preciseio.PreciseReader,v reflect.Value) error {
 	pv := v
 	if pv.Type()!=s.t.Elem() {
 		pv = reflect.New(s.t.Elem()).Elem()
-		v.Set(pv)
+		defer v.Set(pv)
 	}
+	return s.readFields(r,pv)
+}
+func (s *StructBuilder) readFields(r preciseio.PreciseReader,ev reflect.Value) error {
+	if s.versioned { return s.readVersioned(r,ev) }
 	for _,field := range s.fields {
-		e := field.ce.Read(r,pv.FieldByIndex(field.idxs))
+		e := field.ce.Read(r,ev.FieldByIndex(field.idxs))
 		if e!=nil { return e }
 	}
 	return nil
@@ -226,19 +277,17 @@ func (s *StructBuilder) ptrWrite(w *preciseio.PreciseWriter,v reflect.Value) err
 	if v.IsNil() {
 		return w.W.WriteByte(0)
 	}
-	ev := v.Elem()
 	e := w.W.WriteByte(0xff)
 	if e!=nil { return e }
-	for _,field := range s.fields {
-		e = field.ce.Write(w,ev.FieldByIndex(field.idxs))
-		if e!=nil { return e }
-	}
-	return nil
+	return s.writeFields(w,v.Elem())
 }
 func (s *StructBuilder) directWrite(w *preciseio.PreciseWriter,v reflect.Value) error {
-	v = CastV(s.t.Elem(),v)
+	return s.writeFields(w,CastV(s.t.Elem(),v))
+}
+func (s *StructBuilder) writeFields(w *preciseio.PreciseWriter,ev reflect.Value) error {
+	if s.versioned { return s.writeVersioned(w,ev) }
 	for _,field := range s.fields {
-		e := field.ce.Write(w,v.FieldByIndex(field.idxs))
+		e := field.ce.Write(w,ev.FieldByIndex(field.idxs))
 		if e!=nil { return e }
 	}
 	return nil
@@ -251,20 +300,24 @@ type typeSwitchItem struct{
 
 // Creates a type-mapping for up to 255 different types.
 // The type is indicated by the first byte.
+//
+// Unknown bytes are decoded as nil. See Registry for more types and strict errors.
 type TypeSwitch struct{
 	def byte
 	oth map[byte]typeSwitchItem
+	typ map[reflect.Type]byte
 }
 
 // Starts a new type switching. A default key must be specified.
 func Switch(def byte) *TypeSwitch {
-	return &TypeSwitch{def,make(map[byte]typeSwitchItem)}
+	return &TypeSwitch{def,make(map[byte]typeSwitchItem),make(map[reflect.Type]byte)}
 }
 func (t *TypeSwitch) add(b byte, i typeSwitchItem) *TypeSwitch {
 	_,hasByte1 := t.oth[b]
 	hasByte2 := t.def==b
 	if hasByte1||hasByte2 { panic(fmt.Sprintf("Name-Conflict: byte %d is already in use.",b)) }
 	t.oth[b] = i
+	if _,ok := t.typ[i.t]; !ok { t.typ[i.t] = b }
 	return t
 }
 func (t *TypeSwitch) AddType(b byte,i interface{}) *TypeSwitch {
@@ -320,6 +373,9 @@ func (t *TypeSwitch) AddTypeContainerWithP(b byte,i interface{},ce CodecElement)
 }
 
 func (t *TypeSwitch) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	l,e := enter(r)
+	if e!=nil { return e }
+	defer l.leave()
 	b,e := r.R.ReadByte()
 	if e!=nil { return e }
 	i,ok := t.oth[b]
@@ -335,13 +391,10 @@ func (t *TypeSwitch) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
 	if !dv.IsValid() {
 		return w.W.WriteByte(t.def)
 	}
-	dvt := dv.Type()
-	for b,i := range t.oth {
-		if dvt!=i.t { continue }
-		e := w.W.WriteByte(b)
-		if e!=nil { return e }
-		return i.ce.Write(w,dv)
-	}
-	return w.W.WriteByte(t.def)
+	b,ok := t.typ[dv.Type()]
+	if !ok { return w.W.WriteByte(t.def) }
+	e := w.W.WriteByte(b)
+	if e!=nil { return e }
+	return t.oth[b].ce.Write(w,dv)
 }
 
@@ -0,0 +1,81 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "bytes"
+import "math"
+import "reflect"
+import "testing"
+
+func TestMapOrder(t *testing.T) {
+	ce := ForType(map[string]int(nil))
+	a := map[string]int{}
+	b := map[string]int{}
+	keys := []string{"b","a","ab","","c","aa","zz","x"}
+	for i,k := range keys {
+		a[k] = i
+		b[keys[len(keys)-1-i]] = len(keys)-1-i
+	}
+	want := encode(t,ce,a)
+	for i := 0 ; i<20 ; i++ {
+		if got := encode(t,ce,b); !bytes.Equal(got,want) { t.Fatalf("got %x, want %x",got,want) }
+	}
+	// The keys are sorted by their encoding, which is the length-prefixed string: "" (3), "a" (1), ...
+	if !bytes.HasPrefix(want,[]byte{0xff,8, 0,6, 1,'a',2}) { t.Errorf("first entries: %x",want) }
+	
+	r,e := decode(ce,want)
+	if e!=nil { t.Fatal(e) }
+	if !reflect.DeepEqual(r,a) { t.Errorf("got %v, want %v",r,a) }
+}
+
+func TestMapNil(t *testing.T) {
+	ce := ForType(map[int][]byte(nil))
+	for _,m := range []map[int][]byte{nil,{},{-1:nil,1:[]byte("x")}} {
+		r,e := decode(ce,encode(t,ce,m))
+		if e!=nil { t.Fatal(e) }
+		if got,_ := r.(map[int][]byte); (got==nil)!=(m==nil) || len(got)!=len(m) { t.Errorf("got %#v, want %#v",got,m) }
+	}
+}
+
+type nanMap struct{
+	M map[float64]int
+}
+
+func TestMapNaNKeys(t *testing.T) {
+	ce := ForStruct(new(nanMap))
+	data := encode(t,ce,&nanMap{map[float64]int{math.NaN():1,2:3}})
+	r,e := decode(ce,data)
+	if e!=nil { t.Fatal(e) }
+	m := r.(*nanMap).M
+	for k,v := range m {
+		if k!=k && v!=1 || k==k && (k!=2 || v!=3) { t.Errorf("wrong entry %v: %v",k,v) }
+	}
+	if len(m)!=2 { t.Errorf("got %v",m) }
+	
+	// A reused map is cleared, although NaN keys can't be deleted.
+	dst := &nanMap{map[float64]int{math.NaN():5}}
+	if e = DeserializeInto(ce,reader(encode(t,ce,&nanMap{map[float64]int{1:1}})),dst); e!=nil { t.Fatal(e) }
+	if !reflect.DeepEqual(dst.M,map[float64]int{1:1}) { t.Errorf("got %v",dst.M) }
+}
@@ -0,0 +1,372 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "bytes"
+import "fmt"
+import "io"
+import "math"
+import "reflect"
+import "time"
+import "github.com/byte-mug/golibs/preciseio"
+
+// A preciseio.Reader keeping track of the byte offset.
+type offsetReader struct{
+	r   preciseio.Reader
+	off int64
+}
+func (o *offsetReader) Read(p []byte) (int,error) {
+	n,e := o.r.Read(p)
+	o.off += int64(n)
+	return n,e
+}
+func (o *offsetReader) ReadByte() (byte,error) {
+	b,e := o.r.ReadByte()
+	if e==nil { o.off++ }
+	return b,e
+}
+
+// A decoded value, as seen by the codec tree.
+type dumpNode struct{
+	off  int64
+	ce   CodecElement
+	kind string      // "int", "uint", "string", "slice", "struct", ...
+	val  interface{} // the scalar value, or the type tag of a switch or registry
+	null bool        // nil pointer, map, struct or interface
+	bad  bool        // decoding failed
+	desc string      // additional annotation
+	kids []dumpKid
+}
+type dumpKid struct{
+	name string
+	node *dumpNode
+}
+
+func (n *dumpNode) add(name string, k *dumpNode) {
+	n.kids = append(n.kids,dumpKid{name,k})
+}
+
+// Walks the codec tree and decodes the values without knowing their Go types.
+type dumper struct{
+	o  *offsetReader
+	pr preciseio.PreciseReader
+}
+func newDumper(r preciseio.Reader, base int64) *dumper {
+	o := &offsetReader{r,base}
+	return &dumper{o,preciseio.PreciseReader{R:o}}
+}
+
+// Decodes a value. On error, the partially decoded value is returned as well.
+func (d *dumper) node(ce CodecElement) (*dumpNode,error) {
+	switch c := ce.(type) {
+	case ceStripawayPtr: return d.node(c.child)
+	case ceAddPtr: return d.node(c.child)
+	case *ceLazy: return d.node(c.get())
+	}
+	n := &dumpNode{off:d.o.off,ce:ce}
+	var e error
+	switch c := ce.(type) {
+	case ceInt:
+		n.kind = "int"
+		n.val,e = d.pr.ReadVarint()
+	case ceUint:
+		n.kind = "uint"
+		n.val,e = d.pr.ReadUvarint()
+	case ceByte:
+		n.kind = "uint8"
+		n.val,e = d.pr.R.ReadByte()
+	case ceSbyte:
+		var b byte
+		n.kind = "int8"
+		b,e = d.pr.R.ReadByte()
+		n.val = int8(b)
+	case ceBool:
+		var b byte
+		n.kind = "bool"
+		b,e = d.pr.R.ReadByte()
+		n.val = b!=0
+	case ceFloat32:
+		var i uint32
+		n.kind = "float32"
+		i,e = d.pr.ReadUint32()
+		n.val = float64(math.Float32frombits(i))
+	case ceFloat64:
+		var i uint64
+		n.kind = "float64"
+		i,e = d.pr.ReadUint64()
+		n.val = math.Float64frombits(i)
+	case ceComplex64:
+		var rp,ip uint32
+		n.kind = "complex64"
+		if rp,e = d.pr.ReadUint32(); e==nil { ip,e = d.pr.ReadUint32() }
+		n.val = complex(float64(math.Float32frombits(rp)),float64(math.Float32frombits(ip)))
+	case ceComplex128:
+		var rp,ip uint64
+		n.kind = "complex128"
+		if rp,e = d.pr.ReadUint64(); e==nil { ip,e = d.pr.ReadUint64() }
+		n.val = complex(math.Float64frombits(rp),math.Float64frombits(ip))
+	case ceString:
+		var b []byte
+		n.kind = "string"
+		b,e = d.pr.ReadBlob()
+		n.val = string(b)
+	case ceBlob:
+		n.kind = "bytes"
+		n.val,e = d.pr.ReadBlob()
+	case ceTime:
+		n.kind = "time"
+		n.val,e = readTime(d.pr)
+	case ceSlice:
+		n.kind = "slice"
+		n.desc = c.t.String()
+		e = d.list(n,c.child,-1)
+	case ceArray:
+		n.kind = "array"
+		n.desc = c.t.String()
+		e = d.list(n,c.child,c.t.Len())
+	case ceMap:
+		n.kind = "map"
+		n.desc = c.t.String()
+		e = d.dmap(n,c)
+	case cePtr:
+		var b byte
+		n.kind = "ptr"
+		n.desc = c.t.String()
+		b,e = d.pr.R.ReadByte()
+		if e==nil {
+			if b==0 { n.null = true; break }
+			var k *dumpNode
+			k,e = d.node(c.child)
+			n.add("",k)
+		}
+	case *StructBuilder:
+		n.kind = "struct"
+		n.desc = c.t.Elem().String()
+		e = d.strct(n,c)
+	case *TypeSwitch:
+		var b byte
+		n.kind = "switch"
+		b,e = d.pr.R.ReadByte()
+		n.val = b
+		if e!=nil { break }
+		i,ok := c.oth[b]
+		if !ok { n.null = true; break }
+		n.desc = i.t.String()
+		var k *dumpNode
+		k,e = d.node(i.ce)
+		n.add("",k)
+	case *Registry:
+		n.kind = "registry"
+		e = d.registry(n,c)
+	default:
+		n.kind = "opaque"
+		n.desc = fmt.Sprintf("%T",ce)
+		n.val,e = opaqueRead(ce,d.pr)
+	}
+	n.bad = e!=nil
+	return n,e
+}
+
+// Decodes a value using a codec unknown to the dumper.
+func opaqueRead(ce CodecElement, r preciseio.PreciseReader) (i interface{},e error) {
+	defer func() {
+		if p := recover(); p!=nil { e = fmt.Errorf("serializer: %T panicked: %v",ce,p) }
+	}()
+	v := reflect.New(tpAny).Elem()
+	e = ce.Read(r,v)
+	return v.Interface(),e
+}
+
+func (d *dumper) list(n *dumpNode, child CodecElement, length int) error {
+	if length<0 {
+		l,e := d.pr.ReadListLength()
+		if e!=nil { return e }
+		length = l
+	}
+	n.val = length
+	for i := 0 ; i<length ; i++ {
+		k,e := d.node(child)
+		n.add(fmt.Sprintf("[%d]",i),k)
+		if e!=nil { return e }
+	}
+	return nil
+}
+
+func (d *dumper) dmap(n *dumpNode, c ceMap) error {
+	b,e := d.pr.R.ReadByte()
+	if e!=nil { return e }
+	if b==0 { n.null = true; return nil }
+	l,e := d.pr.ReadListLength()
+	if e!=nil { return e }
+	n.val = l
+	for i := 0 ; i<l ; i++ {
+		ent := &dumpNode{off:d.o.off,kind:"entry"}
+		n.add(fmt.Sprintf("[%d]",i),ent)
+		k,e := d.node(c.k)
+		ent.add("key",k)
+		if e!=nil { return e }
+		k,e = d.node(c.v)
+		ent.add("value",k)
+		if e!=nil { return e }
+	}
+	return nil
+}
+
+func (s *StructBuilder) fieldName(i int) string {
+	return s.t.Elem().FieldByIndex(s.fields[i].idxs).Name
+}
+
+func (d *dumper) strct(n *dumpNode, s *StructBuilder) error {
+	if !s.noptr {
+		b,e := d.pr.R.ReadByte()
+		if e!=nil { return e }
+		if b==0 { n.null = true; return nil }
+	}
+	if !s.versioned {
+		for i,f := range s.fields {
+			k,e := d.node(f.ce)
+			n.add(s.fieldName(i),k)
+			if e!=nil { return e }
+		}
+		return nil
+	}
+	n.desc += " (versioned)"
+	for {
+		id,e := d.pr.ReadUvarint()
+		if e!=nil { return e }
+		if id==0 { return nil }
+		l,e := d.pr.ReadListLength()
+		if e!=nil { return e }
+		off := d.o.off
+		blob := make([]byte,l)
+		if _,e = io.ReadFull(d.pr.R,blob); e!=nil { return e }
+		i,ok := s.byID[id]
+		if !ok {
+			n.add(fmt.Sprintf("#%d",id),&dumpNode{off:off,kind:"unknown",val:blob})
+			continue
+		}
+		k,e := newDumper(bytes.NewReader(blob),off).node(s.fields[i].ce)
+		n.add(fmt.Sprintf("%s (id %d)",s.fieldName(i),id),k)
+		if e!=nil { return e }
+	}
+}
+
+func (d *dumper) registry(n *dumpNode, r *Registry) error {
+	var i *registryItem
+	if r.named {
+		b,e := d.pr.ReadBlob()
+		if e!=nil { return e }
+		n.val = string(b)
+		if len(b)==0 { n.null = true; return nil }
+		r.lock.RLock()
+		i = r.byName[string(b)]
+		r.lock.RUnlock()
+		if i==nil { return &UnknownTagError{Name:string(b)} }
+	} else {
+		id,e := d.pr.ReadUvarint()
+		if e!=nil { return e }
+		n.val = id
+		if id==0 { n.null = true; return nil }
+		r.lock.RLock()
+		i = r.byID[id]
+		r.lock.RUnlock()
+		if i==nil { return &UnknownTagError{ID:id} }
+	}
+	n.desc = i.t.String()
+	k,e := d.node(i.ce)
+	n.add("",k)
+	return e
+}
+
+func (n *dumpNode) describe() string {
+	var s string
+	switch n.kind {
+	case "int","uint","uint8","int8","bool","float32","float64","complex64","complex128","string","bytes","time":
+		if n.bad { return n.kind+" ?" }
+	}
+	switch n.kind {
+	case "string": s = fmt.Sprintf("string %q",n.val)
+	case "bytes":
+		b := n.val.([]byte)
+		switch {
+		case len(b)>32: s = fmt.Sprintf("bytes len=%d %x...",len(b),b[:32])
+		case len(b)>0: s = fmt.Sprintf("bytes len=%d %x",len(b),b)
+		default: s = "bytes len=0"
+		}
+	case "unknown": s = fmt.Sprintf("unknown field, %d bytes: %x",len(n.val.([]byte)),n.val)
+	case "time": s = "time "+n.val.(time.Time).Format(time.RFC3339Nano)
+	case "entry": s = ""
+	case "opaque": s = fmt.Sprintf("opaque %#v",n.val)
+	case "slice","array","map":
+		if n.null {
+			s = n.kind+" nil"
+		} else {
+			s = fmt.Sprintf("%s len=%v",n.kind,n.val)
+		}
+	case "ptr","struct":
+		s = n.kind
+		if n.null { s += " nil" }
+	case "switch","registry":
+		s = fmt.Sprintf("%s %#v",n.kind,n.val)
+		if n.null { s += " nil" }
+	default: s = fmt.Sprintf("%s %v",n.kind,n.val)
+	}
+	if n.desc!="" { s += " "+n.desc }
+	return s
+}
+
+func (n *dumpNode) dump(w io.Writer, indent, name string) error {
+	line := n.describe()
+	if name!="" && line!="" { name += ": " }
+	_,e := fmt.Fprintf(w,"%08x  %s%s%s\n",n.off,indent,name,line)
+	if e!=nil { return e }
+	for _,k := range n.kids {
+		e = k.node.dump(w,indent+"  ",k.name)
+		if e!=nil { return e }
+	}
+	return nil
+}
+
+/*
+Decodes a value from r and prints an annotated view of it to w,
+one line per value, prefixed by the byte offset (hexadecimal):
+
+	00000000  struct main.Foo
+	00000001    Naming: int 42
+	00000002    Content: string "hello"
+
+If the input can not be decoded, everything up to the error is printed,
+followed by the error, which is also returned.
+*/
+func Dump(ce CodecElement, r preciseio.PreciseReader, w io.Writer) error {
+	d := newDumper(r.R,0)
+	n,err := d.node(ce)
+	e := n.dump(w,"","")
+	if err!=nil {
+		fmt.Fprintf(w,"%08x  error: %v\n",d.o.off,err)
+		return err
+	}
+	return e
+}
@@ -0,0 +1,163 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "fmt"
+import "io"
+import "reflect"
+import "sync"
+import "github.com/byte-mug/golibs/preciseio"
+
+// A preciseio.Reader on a byte slice, whose blobs can be sub-sliced.
+type sliceReader struct{
+	b []byte
+	i int
+}
+func (s *sliceReader) Read(p []byte) (int,error) {
+	if s.i>=len(s.b) { return 0,io.EOF }
+	n := copy(p,s.b[s.i:])
+	s.i += n
+	return n,nil
+}
+func (s *sliceReader) ReadByte() (byte,error) {
+	if s.i>=len(s.b) { return 0,io.EOF }
+	s.i++
+	return s.b[s.i-1],nil
+}
+func (s *sliceReader) next(n int) ([]byte,error) {
+	if n>len(s.b)-s.i {
+		s.i = len(s.b)
+		return nil,io.ErrUnexpectedEOF
+	}
+	b := s.b[s.i:s.i+n:s.i+n]
+	s.i += n
+	return b,nil
+}
+
+func (l *limitReader) reusing() bool {
+	return l!=nil && l.st.reuse
+}
+
+type intoReader struct{
+	l  limitReader
+	st limitState
+	sr sliceReader
+}
+var pool_intoReader = sync.Pool{ New: func() interface{} { return new(intoReader) } }
+
+// Puts the intoReader back to the pool, without retaining the caller's reader or data.
+func (ir *intoReader) release() {
+	*ir = intoReader{}
+	pool_intoReader.Put(ir)
+}
+
+/*
+Decodes a value from r into *ptr, reusing the memory already referenced by *ptr:
+
+	- slices are decoded into their existing capacity (an empty slice may be decoded as empty instead of nil),
+	- maps are cleared and refilled,
+	- pointed-to values (including structures) are decoded in place,
+	- []byte values are decoded into their existing capacity.
+
+If ce is a codec for *T (such as ForStruct(new(T))), ptr may be a *T as well,
+which is decoded in place. A nil *T in the input sets *ptr to its zero value.
+
+Since memory is reused, the previous value must not be referenced elsewhere.
+Fields, that are not serialized, retain their values. Limits are enforced, if r
+is wrapped by Limits.Wrap().
+
+WARNING: After DeserializeBytesInto, the []byte values of *ptr are sub-slices of its
+input. Decoding into the same value with DeserializeInto overwrites that input.
+Reset those slices to nil first, or keep using DeserializeBytesInto, which never writes
+into existing []byte values.
+*/
+func DeserializeInto(ce CodecElement, r preciseio.PreciseReader, ptr interface{}) error {
+	if l := limitsOf(r); l!=nil {
+		old := l.st.reuse
+		l.st.reuse = true
+		defer func() { l.st.reuse = old }()
+		return deserializeInto(ce,r,ptr)
+	}
+	ir := pool_intoReader.Get().(*intoReader)
+	defer ir.release()
+	ir.st = limitState{reuse:true}
+	ir.l = limitReader{r.R,&ir.st,true}
+	return deserializeInto(ce,preciseio.PreciseReader{R:&ir.l},ptr)
+}
+
+/*
+Like DeserializeInto, but decodes from data and returns the number of bytes consumed.
+The decoded []byte values are sub-slices of data (rather than copies),
+so data must not be modified, as long as the value is in use.
+Don't pass the value to DeserializeInto afterwards: it would decode into data (see there).
+*/
+func DeserializeBytesInto(ce CodecElement, data []byte, ptr interface{}) (int,error) {
+	ir := pool_intoReader.Get().(*intoReader)
+	defer ir.release()
+	ir.sr = sliceReader{b:data}
+	ir.st = limitState{reuse:true,alias:true}
+	ir.l = limitReader{&ir.sr,&ir.st,true}
+	e := deserializeInto(ce,preciseio.PreciseReader{R:&ir.l},ptr)
+	return ir.sr.i,e
+}
+
+func deserializeInto(ce CodecElement, r preciseio.PreciseReader, ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind()!=reflect.Ptr || v.IsNil() { panic(fmt.Sprintf("Required non-nil pointer, but got %T",ptr)) }
+	
+	// A *T for a codec of *T: decode in place.
+	if l,ok := ce.(*ceLazy); ok { ce = l.get() }
+	var child CodecElement
+	switch c := ce.(type) {
+	case *StructBuilder:
+		if !c.noptr && c.t==v.Type() {
+			l,e := enter(r)
+			if e!=nil { return e }
+			defer l.leave()
+			child = structFieldsCodec{c}
+		}
+	case cePtr:
+		if c.t==v.Type() { child = c.child }
+	}
+	if child==nil { return ce.Read(r,v.Elem()) }
+	b,e := r.R.ReadByte()
+	if e!=nil { return e }
+	if b==0 {
+		v.Elem().Set(reflect.Zero(v.Type().Elem()))
+		return nil
+	}
+	return child.Read(r,v.Elem())
+}
+
+// Decodes the fields of a structure (in pointer mode) without the nil marker.
+type structFieldsCodec struct{
+	s *StructBuilder
+}
+func (c structFieldsCodec) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	return c.s.readFields(r,v)
+}
+func (c structFieldsCodec) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
+	return c.s.writeFields(w,v)
+}
@@ -0,0 +1,85 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "bytes"
+import "reflect"
+import "testing"
+
+type intoMsg struct{
+	ID   int
+	Data []byte
+	List []int
+	M    map[string]int
+	Sub  *intoMsg
+}
+
+func TestDeserializeInto(t *testing.T) {
+	ce := ForStruct(new(intoMsg))
+	in := &intoMsg{1,[]byte("abc"),[]int{1,2},map[string]int{"a":1},&intoMsg{ID:2}}
+	data := encode(t,ce,in)
+	
+	dst := &intoMsg{Data:make([]byte,0,8),List:make([]int,0,4),M:map[string]int{"old":1},Sub:new(intoMsg)}
+	data0,list0,m0,sub0 := &dst.Data[:1][0],&dst.List[:1][0],dst.M,dst.Sub
+	if e := DeserializeInto(ce,reader(data),dst); e!=nil { t.Fatal(e) }
+	if !reflect.DeepEqual(dst,in) { t.Errorf("got %+v, want %+v",dst,in) }
+	if &dst.Data[0]!=data0 || &dst.List[0]!=list0 || reflect.ValueOf(dst.M).Pointer()!=reflect.ValueOf(m0).Pointer() || dst.Sub!=sub0 {
+		t.Error("memory was not reused")
+	}
+	
+	allocs := testing.AllocsPerRun(100,func() {
+		if e := DeserializeInto(ce,reader(data),dst); e!=nil { t.Fatal(e) }
+	})
+	fresh := testing.AllocsPerRun(100,func() { decode(ce,data) })
+	if allocs>=fresh { t.Errorf("%v allocations per run, %v without reuse",allocs,fresh) }
+}
+
+func TestDeserializeBytesInto(t *testing.T) {
+	ce := ForStruct(new(intoMsg))
+	data := encode(t,ce,&intoMsg{ID:1,Data:[]byte("abc")})
+	data = append(data,0xee) // Trailing bytes are not consumed.
+	orig := append([]byte(nil),data...)
+	
+	dst := &intoMsg{Data:make([]byte,8)}
+	n,e := DeserializeBytesInto(ce,data,dst)
+	if e!=nil { t.Fatal(e) }
+	if n!=len(data)-1 { t.Errorf("consumed %d of %d bytes",n,len(data)) }
+	if string(dst.Data)!="abc" || cap(dst.Data)!=3 { t.Errorf("Data = %q (cap %d)",dst.Data,cap(dst.Data)) }
+	if i := bytes.Index(data,[]byte("abc")); &data[i]!=&dst.Data[0] { t.Error("Data does not alias the input") }
+	
+	// Decoding again never writes into the aliased input.
+	other := encode(t,ce,&intoMsg{ID:2,Data:[]byte("xyz")})
+	if _,e = DeserializeBytesInto(ce,other,dst); e!=nil { t.Fatal(e) }
+	if !bytes.Equal(data,orig) { t.Errorf("input was modified: %x",data) }
+	
+	if allocs := testing.AllocsPerRun(100,func() { DeserializeBytesInto(ce,data,dst) }); allocs>0 { t.Errorf("%v allocations per run",allocs) }
+}
+
+func TestDeserializeIntoNil(t *testing.T) {
+	ce := ForStruct(new(intoMsg))
+	dst := &intoMsg{ID:5}
+	if e := DeserializeInto(ce,reader([]byte{0}),dst); e!=nil || !reflect.DeepEqual(dst,&intoMsg{}) { t.Errorf("got %+v, %v",dst,e) }
+	expectPanic(t,"non-pointer",func() { DeserializeInto(ce,reader([]byte{0}),intoMsg{}) })
+}
@@ -0,0 +1,467 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "bytes"
+import "encoding/base64"
+import "encoding/json"
+import "fmt"
+import "math"
+import "reflect"
+import "sort"
+import "strconv"
+import "strings"
+import "time"
+import "github.com/byte-mug/golibs/preciseio"
+
+/*
+JSON representation of serialized values, as used by ToJSON and FromJSON:
+
+	integers, floats    number ("NaN", "+Inf" and "-Inf" as string)
+	complex numbers     [real, imag]
+	string              string
+	[]byte              base64 string
+	time.Time           RFC 3339 string
+	slices, arrays      array
+	maps                object, if the key is a string, otherwise [[key, value], ...]
+	pointers            null or the value
+	structures          object (unknown fields of versioned structures as "#<id>": base64)
+	TypeSwitch,Registry null or {"type": tag, "value": value}
+
+FromJSON encodes null and missing fields as the zero value. Missing fields of versioned
+structures are omitted, so they are decoded as missing.
+*/
+
+// Decodes a value from r and transcodes it to (indented) JSON.
+func ToJSON(ce CodecElement, r preciseio.PreciseReader) ([]byte,error) {
+	n,e := newDumper(r.R,0).node(ce)
+	if e!=nil { return nil,e }
+	buf := new(bytes.Buffer)
+	e = n.json(buf)
+	if e!=nil { return nil,e }
+	out := new(bytes.Buffer)
+	e = json.Indent(out,buf.Bytes(),"","\t")
+	if e!=nil { return nil,e }
+	out.WriteByte('\n')
+	return out.Bytes(),nil
+}
+
+func jsonString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	buf.Truncate(buf.Len()-1) // The trailing newline.
+}
+
+func jsonFloat(buf *bytes.Buffer, f float64, bits int) {
+	switch {
+	case math.IsNaN(f): buf.WriteString(`"NaN"`)
+	case math.IsInf(f,1): buf.WriteString(`"+Inf"`)
+	case math.IsInf(f,-1): buf.WriteString(`"-Inf"`)
+	default: buf.WriteString(strconv.FormatFloat(f,'g',-1,bits))
+	}
+}
+
+func (n *dumpNode) json(buf *bytes.Buffer) error {
+	if n.null {
+		buf.WriteString("null")
+		return nil
+	}
+	switch n.kind {
+	case "int","uint","uint8","int8","bool": fmt.Fprint(buf,n.val)
+	case "float32": jsonFloat(buf,n.val.(float64),32)
+	case "float64": jsonFloat(buf,n.val.(float64),64)
+	case "complex64","complex128":
+		bits := 64
+		if n.kind=="complex64" { bits = 32 }
+		c := n.val.(complex128)
+		buf.WriteByte('[')
+		jsonFloat(buf,real(c),bits)
+		buf.WriteByte(',')
+		jsonFloat(buf,imag(c),bits)
+		buf.WriteByte(']')
+	case "string": jsonString(buf,n.val.(string))
+	case "bytes","unknown": jsonString(buf,base64.StdEncoding.EncodeToString(n.val.([]byte)))
+	case "time": jsonString(buf,n.val.(time.Time).Format(time.RFC3339Nano))
+	case "slice","array":
+		buf.WriteByte('[')
+		for i,k := range n.kids {
+			if i>0 { buf.WriteByte(',') }
+			if e := k.node.json(buf); e!=nil { return e }
+		}
+		buf.WriteByte(']')
+	case "map":
+		_,obj := n.ce.(ceMap).k.(ceString)
+		if obj { buf.WriteByte('{') } else { buf.WriteByte('[') }
+		for i,k := range n.kids {
+			if i>0 { buf.WriteByte(',') }
+			key,val := k.node.kids[0].node,k.node.kids[1].node
+			if obj {
+				jsonString(buf,key.val.(string))
+				buf.WriteByte(':')
+			} else {
+				buf.WriteByte('[')
+				if e := key.json(buf); e!=nil { return e }
+				buf.WriteByte(',')
+			}
+			if e := val.json(buf); e!=nil { return e }
+			if !obj { buf.WriteByte(']') }
+		}
+		if obj { buf.WriteByte('}') } else { buf.WriteByte(']') }
+	case "ptr": return n.kids[0].node.json(buf)
+	case "struct":
+		buf.WriteByte('{')
+		for i,k := range n.kids {
+			if i>0 { buf.WriteByte(',') }
+			name := k.name
+			if j := strings.IndexByte(name,' '); j>=0 { name = name[:j] } // Strip the " (id N)".
+			jsonString(buf,name)
+			buf.WriteByte(':')
+			if e := k.node.json(buf); e!=nil { return e }
+		}
+		buf.WriteByte('}')
+	case "switch","registry":
+		buf.WriteString(`{"type":`)
+		if s,ok := n.val.(string); ok { jsonString(buf,s) } else { fmt.Fprint(buf,n.val) }
+		buf.WriteString(`,"value":`)
+		if e := n.kids[0].node.json(buf); e!=nil { return e }
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("serializer: %s can not be transcoded to JSON",n.desc)
+	}
+	return nil
+}
+
+// Encodes the JSON document data (see ToJSON) to w, using the codec ce.
+func FromJSON(ce CodecElement, data []byte, w *preciseio.PreciseWriter) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if e := dec.Decode(&v); e!=nil { return e }
+	return fromJSON(ce,v,w,"$")
+}
+
+func jsonError(path string, want string, v interface{}) error {
+	return fmt.Errorf("serializer: %s: expected %s, got %#v",path,want,v)
+}
+
+func jsonInt(v interface{}, path string, bits int) (int64,error) {
+	if v==nil { return 0,nil }
+	num,ok := v.(json.Number)
+	if !ok { return 0,jsonError(path,"integer",v) }
+	i,e := strconv.ParseInt(string(num),10,bits)
+	if e!=nil { return 0,jsonError(path,"integer",v) }
+	return i,nil
+}
+func jsonUint(v interface{}, path string, bits int) (uint64,error) {
+	if v==nil { return 0,nil }
+	num,ok := v.(json.Number)
+	if !ok { return 0,jsonError(path,"unsigned integer",v) }
+	i,e := strconv.ParseUint(string(num),10,bits)
+	if e!=nil { return 0,jsonError(path,"unsigned integer",v) }
+	return i,nil
+}
+func jsonToFloat(v interface{}, path string, bits int) (float64,error) {
+	switch f := v.(type) {
+	case nil: return 0,nil
+	case json.Number:
+		r,e := strconv.ParseFloat(string(f),bits)
+		if e==nil { return r,nil }
+	case string:
+		switch f {
+		case "NaN": return math.NaN(),nil
+		case "+Inf": return math.Inf(1),nil
+		case "-Inf": return math.Inf(-1),nil
+		}
+	}
+	return 0,jsonError(path,"number",v)
+}
+func jsonComplex(v interface{}, path string, bits int) (complex128,error) {
+	if v==nil { return 0,nil }
+	a,ok := v.([]interface{})
+	if !ok || len(a)!=2 { return 0,jsonError(path,"[real, imag]",v) }
+	rp,e := jsonToFloat(a[0],path,bits)
+	if e!=nil { return 0,e }
+	ip,e := jsonToFloat(a[1],path,bits)
+	if e!=nil { return 0,e }
+	return complex(rp,ip),nil
+}
+func jsonToString(v interface{}, path string) (string,error) {
+	if v==nil { return "",nil }
+	s,ok := v.(string)
+	if !ok { return "",jsonError(path,"string",v) }
+	return s,nil
+}
+func jsonBytes(v interface{}, path string) ([]byte,error) {
+	s,e := jsonToString(v,path)
+	if e!=nil { return nil,e }
+	b,e := base64.StdEncoding.DecodeString(s)
+	if e!=nil { return nil,jsonError(path,"base64 string",v) }
+	return b,nil
+}
+func jsonArray(v interface{}, path string) ([]interface{},error) {
+	if v==nil { return nil,nil }
+	a,ok := v.([]interface{})
+	if !ok { return nil,jsonError(path,"array",v) }
+	return a,nil
+}
+
+func fromJSON(ce CodecElement, v interface{}, w *preciseio.PreciseWriter, path string) error {
+	switch c := ce.(type) {
+	case ceStripawayPtr: return fromJSON(c.child,v,w,path)
+	case ceAddPtr: return fromJSON(c.child,v,w,path)
+	case *ceLazy: return fromJSON(c.get(),v,w,path)
+	case ceInt:
+		i,e := jsonInt(v,path,64)
+		if e!=nil { return e }
+		return w.WriteVarint(i)
+	case ceUint:
+		i,e := jsonUint(v,path,64)
+		if e!=nil { return e }
+		return w.WriteUvarint(i)
+	case ceByte:
+		i,e := jsonUint(v,path,8)
+		if e!=nil { return e }
+		return w.W.WriteByte(byte(i))
+	case ceSbyte:
+		i,e := jsonInt(v,path,8)
+		if e!=nil { return e }
+		return w.W.WriteByte(byte(int8(i)))
+	case ceBool:
+		b,ok := v.(bool)
+		if !ok && v!=nil { return jsonError(path,"boolean",v) }
+		return ceBool{}.Write(w,reflect.ValueOf(b))
+	case ceFloat32:
+		f,e := jsonToFloat(v,path,32)
+		if e!=nil { return e }
+		return w.WriteUint32(math.Float32bits(float32(f)))
+	case ceFloat64:
+		f,e := jsonToFloat(v,path,64)
+		if e!=nil { return e }
+		return w.WriteUint64(math.Float64bits(f))
+	case ceComplex64:
+		f,e := jsonComplex(v,path,32)
+		if e!=nil { return e }
+		return c.Write(w,reflect.ValueOf(complex64(f)))
+	case ceComplex128:
+		f,e := jsonComplex(v,path,64)
+		if e!=nil { return e }
+		return c.Write(w,reflect.ValueOf(f))
+	case ceString:
+		s,e := jsonToString(v,path)
+		if e!=nil { return e }
+		return w.WriteBlob([]byte(s))
+	case ceBlob:
+		b,e := jsonBytes(v,path)
+		if e!=nil { return e }
+		return w.WriteBlob(b)
+	case ceTime:
+		var t time.Time
+		if v!=nil {
+			s,e := jsonToString(v,path)
+			if e!=nil { return e }
+			t,e = time.Parse(time.RFC3339Nano,s)
+			if e!=nil { return jsonError(path,"RFC 3339 time",v) }
+		}
+		return c.Write(w,reflect.ValueOf(t))
+	case ceSlice:
+		a,e := jsonArray(v,path)
+		if e!=nil { return e }
+		if e = w.WriteListLength(len(a)); e!=nil { return e }
+		for i,ev := range a {
+			if e = fromJSON(c.child,ev,w,fmt.Sprintf("%s[%d]",path,i)); e!=nil { return e }
+		}
+		return nil
+	case ceArray:
+		a,e := jsonArray(v,path)
+		if e!=nil { return e }
+		if a!=nil && len(a)!=c.t.Len() { return jsonError(path,fmt.Sprintf("array of length %d",c.t.Len()),v) }
+		for i := 0 ; i<c.t.Len() ; i++ {
+			var ev interface{}
+			if a!=nil { ev = a[i] }
+			if e = fromJSON(c.child,ev,w,fmt.Sprintf("%s[%d]",path,i)); e!=nil { return e }
+		}
+		return nil
+	case ceMap: return mapFromJSON(c,v,w,path)
+	case cePtr:
+		if v==nil { return w.W.WriteByte(0) }
+		if e := w.W.WriteByte(0xff); e!=nil { return e }
+		return fromJSON(c.child,v,w,path)
+	case *StructBuilder: return c.fromJSON(v,w,path)
+	case *TypeSwitch:
+		if v==nil { return w.W.WriteByte(c.def) }
+		tag,val,e := jsonTagged(v,path)
+		if e!=nil { return e }
+		b,e := jsonUint(tag,path+".type",8)
+		if e!=nil { return e }
+		i,ok := c.oth[byte(b)]
+		if !ok { return jsonError(path+".type","known type tag",tag) }
+		if e = w.W.WriteByte(byte(b)); e!=nil { return e }
+		return fromJSON(i.ce,val,w,path+".value")
+	case *Registry: return c.fromJSON(v,w,path)
+	}
+	return fmt.Errorf("serializer: %s: %T can not be transcoded from JSON",path,ce)
+}
+
+func jsonTagged(v interface{}, path string) (interface{},interface{},error) {
+	m,ok := v.(map[string]interface{})
+	if !ok { return nil,nil,jsonError(path,`{"type": tag, "value": value}`,v) }
+	for k := range m {
+		if k!="type" && k!="value" { return nil,nil,fmt.Errorf("serializer: %s: unknown field %q",path,k) }
+	}
+	return m["type"],m["value"],nil
+}
+
+func mapFromJSON(c ceMap, v interface{}, w *preciseio.PreciseWriter, path string) error {
+	if v==nil { return w.W.WriteByte(0) }
+	var keys,vals []interface{}
+	if _,ok := c.k.(ceString); ok {
+		m,ok := v.(map[string]interface{})
+		if !ok { return jsonError(path,"object",v) }
+		for k,mv := range m {
+			keys = append(keys,k)
+			vals = append(vals,mv)
+		}
+	} else {
+		a,e := jsonArray(v,path)
+		if e!=nil { return e }
+		for _,ent := range a {
+			kv,ok := ent.([]interface{})
+			if !ok || len(kv)!=2 { return jsonError(path,"[key, value]",ent) }
+			keys = append(keys,kv[0])
+			vals = append(vals,kv[1])
+		}
+	}
+	
+	// Sort the entries by their encoded keys, like ceMap.Write().
+	buf := new(bytes.Buffer)
+	kw := preciseio.PreciseWriterFromPool()
+	defer kw.PutToPool()
+	kw.W = buf
+	offs := make([]int,len(keys)+1)
+	for i,k := range keys {
+		if e := fromJSON(c.k,k,kw,fmt.Sprintf("%s<key %d>",path,i)); e!=nil { return e }
+		offs[i+1] = buf.Len()
+	}
+	kbuf := buf.Bytes()
+	idx := make([]int,len(keys))
+	for i := range idx { idx[i] = i }
+	sort.Slice(idx,func(i,j int) bool {
+		a,b := idx[i],idx[j]
+		return bytes.Compare(kbuf[offs[a]:offs[a+1]],kbuf[offs[b]:offs[b+1]])<0
+	})
+	
+	if e := w.W.WriteByte(0xff); e!=nil { return e }
+	if e := w.WriteListLength(len(keys)); e!=nil { return e }
+	for _,i := range idx {
+		if _,e := w.W.Write(kbuf[offs[i]:offs[i+1]]); e!=nil { return e }
+		if e := fromJSON(c.v,vals[i],w,fmt.Sprintf("%s<value %d>",path,i)); e!=nil { return e }
+	}
+	return nil
+}
+
+func (s *StructBuilder) fromJSON(v interface{}, w *preciseio.PreciseWriter, path string) error {
+	if !s.noptr {
+		if v==nil { return w.W.WriteByte(0) }
+		if e := w.W.WriteByte(0xff); e!=nil { return e }
+	}
+	var m map[string]interface{}
+	if v!=nil {
+		var ok bool
+		m,ok = v.(map[string]interface{})
+		if !ok { return jsonError(path,"object",v) }
+	}
+	known := make(map[string]bool)
+	for i := range s.fields { known[s.fieldName(i)] = true }
+	var unknown []uint64
+	for k := range m {
+		if known[k] { continue }
+		id,e := strconv.ParseUint(strings.TrimPrefix(k,"#"),10,64)
+		if !s.versioned || !strings.HasPrefix(k,"#") || e!=nil || id==0 { return fmt.Errorf("serializer: %s: unknown field %q",path,k) }
+		unknown = append(unknown,id)
+	}
+	if !s.versioned {
+		for i,f := range s.fields {
+			name := s.fieldName(i)
+			if e := fromJSON(f.ce,m[name],w,path+"."+name); e!=nil { return e }
+		}
+		return nil
+	}
+	
+	// Only the fields present in the object are written, ordered by their ids, like the input of ToJSON.
+	var fields []int
+	for i := range s.fields {
+		if _,ok := m[s.fieldName(i)]; ok { fields = append(fields,i) }
+	}
+	sort.Slice(unknown,func(i,j int) bool { return unknown[i]<unknown[j] })
+	buf := new(bytes.Buffer)
+	fw := preciseio.PreciseWriterFromPool()
+	defer fw.PutToPool()
+	fw.W = buf
+	for len(fields)>0 || len(unknown)>0 {
+		var id uint64
+		buf.Reset()
+		if len(unknown)==0 || len(fields)>0 && s.fields[fields[0]].id<unknown[0] {
+			f := s.fields[fields[0]]
+			name := s.fieldName(fields[0])
+			fields = fields[1:]
+			id = f.id
+			if e := fromJSON(f.ce,m[name],fw,path+"."+name); e!=nil { return e }
+		} else {
+			id = unknown[0]
+			unknown = unknown[1:]
+			key := fmt.Sprintf("#%d",id)
+			b,e := jsonBytes(m[key],path+"."+key)
+			if e!=nil { return e }
+			buf.Write(b)
+		}
+		if e := w.WriteUvarint(id); e!=nil { return e }
+		if e := w.WriteBlob(buf.Bytes()); e!=nil { return e }
+	}
+	return w.WriteUvarint(0)
+}
+
+func (r *Registry) fromJSON(v interface{}, w *preciseio.PreciseWriter, path string) error {
+	if v==nil { return r.Write(w,reflect.ValueOf(nil)) }
+	tag,val,e := jsonTagged(v,path)
+	if e!=nil { return e }
+	var i *registryItem
+	r.lock.RLock()
+	if r.named {
+		name,_ := tag.(string)
+		i = r.byName[name]
+	} else if id,err := jsonUint(tag,path+".type",64); err==nil {
+		i = r.byID[id]
+	}
+	r.lock.RUnlock()
+	if i==nil || tag==nil { return jsonError(path+".type","known type tag",tag) }
+	if r.named {
+		e = w.WriteBlob([]byte(i.name))
+	} else {
+		e = w.WriteUvarint(i.id)
+	}
+	if e!=nil { return e }
+	return fromJSON(i.ce,val,w,path+".value")
+}
@@ -0,0 +1,121 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "bytes"
+import "math"
+import "strings"
+import "testing"
+import "time"
+
+type jsonMsg struct{
+	I   int
+	U   uint16
+	S   string
+	B   []byte
+	F   float64
+	F32 float32
+	C   complex128
+	T   time.Time
+	P   *int
+	M   map[string]int
+	MI  map[int]string
+	A   [2]bool
+	L   [][]int8
+	Sh  testShape
+	V   *recordV2
+}
+
+func jsonSample() *jsonMsg {
+	one := 1
+	return &jsonMsg{
+		I:-3,U:7,S:"a\"b",B:[]byte{0,1,2},F:math.Inf(-1),F32:0.5,C:complex(1,2),
+		T:time.Date(2020,1,2,3,4,5,6,time.UTC),P:&one,
+		M:map[string]int{"x":1,"y":2},MI:map[int]string{2:"b",1:"a"},
+		A:[2]bool{true,false},L:[][]int8{{1},nil,{-1,2}},Sh:&testRect{2,3},
+		V:&recordV2{ID:4,Tags:[]string{"t"}},
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	ce := ForStruct(new(jsonMsg))
+	for _,v := range []*jsonMsg{jsonSample(),{},nil} {
+		data := encode(t,ce,v)
+		js,e := ToJSON(ce,reader(data))
+		if e!=nil { t.Fatal(e) }
+		w := newWriter()
+		buf := w.W.(*bytes.Buffer)
+		if e = FromJSON(ce,js,w); e!=nil { t.Fatalf("FromJSON(%s): %v",js,e) }
+		if !bytes.Equal(buf.Bytes(),data) { t.Errorf("%s\ngot  %x\nwant %x",js,buf.Bytes(),data) }
+	}
+}
+
+func TestJSONVersioned(t *testing.T) {
+	// Unknown fields of versioned structures are preserved as "#<id>".
+	data := encode(t,ForStructVersioned(new(recordV2)),&recordV2{ID:1,Email:"e",Tags:[]string{"x"}})
+	ce := ForStructVersioned(new(recordV1))
+	js,e := ToJSON(ce,reader(data))
+	if e!=nil { t.Fatal(e) }
+	if !strings.Contains(string(js),`"#3"`) { t.Errorf("unknown field missing in %s",js) }
+	w := newWriter()
+	if e = FromJSON(ce,js,w); e!=nil { t.Fatal(e) }
+	if got := w.W.(*bytes.Buffer).Bytes(); !bytes.Equal(got,data) { t.Errorf("got %x, want %x",got,data) }
+}
+
+func TestJSONErrors(t *testing.T) {
+	ce := ForStruct(new(jsonMsg))
+	for _,js := range []string{
+		`{"I":"x"}`,
+		`{"Nope":1}`,
+		`{"T":"yesterday"}`,
+		`{"Sh":{"type":"circle","value":{}}}`,
+		`[1]`,
+	} {
+		if e := FromJSON(ce,[]byte(js),newWriter()); e==nil { t.Errorf("%s: no error",js) }
+	}
+}
+
+func TestDump(t *testing.T) {
+	ce := ForStruct(new(jsonMsg))
+	data := encode(t,ce,jsonSample())
+	out := new(bytes.Buffer)
+	if e := Dump(ce,reader(data),out); e!=nil { t.Fatal(e) }
+	lines := strings.Split(out.String(),"\n")
+	for _,want := range []string{
+		"00000000  struct serializer.jsonMsg",
+		"00000003    S: string \"a\\\"b\"",
+		"0000003c        key: int 2",
+		"00000048    Sh: registry \"rect\" *serializer.testRect",
+		"00000056        Data: bytes len=0",
+	} {
+		found := false
+		for _,l := range lines { found = found || l==want }
+		if !found { t.Errorf("missing line %q in\n%s",want,out) }
+	}
+	
+	out.Reset()
+	if e := Dump(ce,reader(data[:20]),out); e==nil { t.Error("truncated input: no error") }
+	if want := "00000013    F32: float32 ?\n00000014  error: unexpected EOF\n" ; !strings.HasSuffix(out.String(),want) { t.Errorf("got\n%s",out) }
+}
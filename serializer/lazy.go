@@ -0,0 +1,111 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "fmt"
+import "reflect"
+import "sync"
+import "sync/atomic"
+import "github.com/byte-mug/golibs/preciseio"
+
+type ceLazy struct{
+	lock sync.Mutex
+	f    func() CodecElement
+	ce   atomic.Value
+}
+func (l *ceLazy) get() CodecElement {
+	if ce,ok := l.ce.Load().(CodecElement); ok { return ce }
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if ce,ok := l.ce.Load().(CodecElement); ok { return ce }
+	/*
+	If f panics, the codec stays unresolved, so the panic is repeated on every use
+	(or f succeeds later, eg. once Define() has been called).
+	*/
+	ce := l.f()
+	if ce==nil { panic("Lazy codec resolved to <nil>") }
+	if ce==CodecElement(l) { panic("Lazy codec resolved to itself") }
+	l.ce.Store(ce)
+	return ce
+}
+func (l *ceLazy) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	return l.get().Read(r,v)
+}
+func (l *ceLazy) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
+	return l.get().Write(w,v)
+}
+
+/*
+Returns a codec, that calls f on first use, and delegates to the codec returned by f.
+This allows recursive types to be described:
+
+	type Node struct{
+		Value    int
+		Children []*Node
+	}
+
+	var ser_Node serializer.CodecElement
+
+	func init() {
+		ser_Node = serializer.With(new(Node)).
+			Field("Value").
+			FieldContainerWith("Children",serializer.Lazy(func() serializer.CodecElement { return ser_Node }))
+	}
+*/
+func Lazy(f func() CodecElement) CodecElement {
+	if f==nil { panic("f must not be <nil>") }
+	return &ceLazy{f:f}
+}
+
+var namedCodecsLock sync.RWMutex
+var namedCodecs = make(map[string]CodecElement)
+
+// Defines a named codec, that can be referenced using Named(name).
+func Define(name string, ce CodecElement) CodecElement {
+	if ce==nil { panic("ce must not be <nil>") }
+	namedCodecsLock.Lock()
+	defer namedCodecsLock.Unlock()
+	if _,ok := namedCodecs[name]; ok { panic(fmt.Sprintf("Name-Conflict: codec %q is already defined.",name)) }
+	namedCodecs[name] = ce
+	return ce
+}
+
+/*
+Returns a reference to the codec defined by Define(name,...), which is resolved on first use.
+The codec can be defined after the reference is taken, so cycles can be resolved by names:
+
+	var ser_Node = serializer.Define("Node",serializer.With(new(Node)).
+		Field("Value").
+		FieldContainerWith("Children",serializer.Named("Node")))
+*/
+func Named(name string) CodecElement {
+	return Lazy(func() CodecElement {
+		namedCodecsLock.RLock()
+		defer namedCodecsLock.RUnlock()
+		ce,ok := namedCodecs[name]
+		if !ok { panic(fmt.Sprintf("Codec %q is not defined.",name)) }
+		return ce
+	})
+}
@@ -0,0 +1,62 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "reflect"
+import "testing"
+
+type lazyNode struct{
+	Value    int
+	Children []*lazyNode
+}
+
+func TestLazyRecursive(t *testing.T) {
+	var ce CodecElement
+	ce = With(new(lazyNode)).Field("Value").FieldContainerWith("Children",Lazy(func() CodecElement { return ce }))
+	in := &lazyNode{1,[]*lazyNode{{2,nil},{3,[]*lazyNode{{4,nil}}}}}
+	out,e := decode(ce,encode(t,ce,in))
+	if e!=nil || !reflect.DeepEqual(out,in) { t.Errorf("got %+v, %v",out,e) }
+}
+
+func TestLazyPanicRepeats(t *testing.T) {
+	calls := 0
+	ce := Lazy(func() CodecElement { calls++; return nil })
+	for i := 0 ; i<3 ; i++ {
+		expectPanic(t,"nil codec",func() { Serialize(ce,newWriter(),0) })
+	}
+	if calls!=3 { t.Errorf("definition called %d times",calls) }
+	self := new(ceLazy)
+	self.f = func() CodecElement { return self }
+	expectPanic(t,"self",func() { Serialize(self,newWriter(),0) })
+	expectPanic(t,"self again",func() { Serialize(self,newWriter(),0) })
+}
+
+func TestNamedLateDefinition(t *testing.T) {
+	ce := Named("serializer.TestNamedLateDefinition")
+	expectPanic(t,"undefined",func() { Serialize(ce,newWriter(),&lazyNode{}) })
+	Define("serializer.TestNamedLateDefinition",With(new(lazyNode)).Field("Value"))
+	out,e := decode(ce,encode(t,ce,&lazyNode{Value:7}))
+	if e!=nil || !reflect.DeepEqual(out,&lazyNode{Value:7}) { t.Errorf("got %+v, %v",out,e) }
+}
@@ -0,0 +1,191 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "fmt"
+import "io"
+import "reflect"
+import "github.com/byte-mug/golibs/preciseio"
+
+// Above this number of elements, slices and maps are not pre-allocated
+// at their full length, so a forged length can not allocate huge amounts of memory.
+const maxPrealloc = 1<<12
+
+/*
+Limits for the deserialization of untrusted input. A zero value means unlimited.
+
+	v,err := serializer.Limits{MaxElements: 1<<16, MaxBytes: 1<<20, MaxDepth: 32}.Deserialize(ce,r)
+*/
+type Limits struct{
+	// The total number of slice- and map-elements. If zero, but MaxBytes is set,
+	// the elements are limited to MaxBytes, so that elements occupying no bytes
+	// (such as struct{}) can not be repeated endlessly.
+	MaxElements int64
+	
+	// The total number of bytes consumed from the input.
+	MaxBytes int64
+	
+	// The nesting depth of structures, pointers, slices, maps, arrays,
+	// type switches and registries.
+	MaxDepth int
+}
+
+// Returned if a limit is exceeded.
+type LimitError struct{
+	Limit string // "MaxElements", "MaxBytes" or "MaxDepth"
+	Max   int64
+}
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("serializer: limit exceeded: %s=%d",e.Limit,e.Max)
+}
+
+type limitState struct{
+	Limits
+	bytes int64
+	elems int64
+	depth int
+	
+	reuse bool // Set by DeserializeInto().
+	alias bool // Set by DeserializeBytesInto().
+}
+
+// A preciseio.Reader enforcing the limits.
+type limitReader struct{
+	r  preciseio.Reader
+	st *limitState
+	count bool // false, if the bytes are already counted by the parent.
+}
+func (l *limitReader) consume(n int) error {
+	if !l.count { return nil }
+	l.st.bytes += int64(n)
+	if l.st.MaxBytes>0 && l.st.bytes>l.st.MaxBytes { return &LimitError{"MaxBytes",l.st.MaxBytes} }
+	return nil
+}
+func (l *limitReader) Read(p []byte) (int,error) {
+	if l.count && l.st.MaxBytes>0 {
+		rest := l.st.MaxBytes-l.st.bytes
+		if rest<=0 { return 0,&LimitError{"MaxBytes",l.st.MaxBytes} }
+		if int64(len(p))>rest { p = p[:rest] }
+	}
+	n,e := l.r.Read(p)
+	l.consume(n)
+	return n,e
+}
+func (l *limitReader) ReadByte() (byte,error) {
+	if e := l.consume(1); e!=nil { return 0,e }
+	return l.r.ReadByte()
+}
+
+// Wraps r, so that the limits are enforced by the codecs reading from it.
+func (l Limits) Wrap(r preciseio.PreciseReader) preciseio.PreciseReader {
+	return preciseio.PreciseReader{R:&limitReader{r.R,&limitState{Limits:l},true}}
+}
+
+// Like Deserialize(ce,r) but enforces the limits.
+func (l Limits) Deserialize(ce CodecElement, r preciseio.PreciseReader) (interface{},error) {
+	return Deserialize(ce,l.Wrap(r))
+}
+
+func limitsOf(r preciseio.PreciseReader) *limitReader {
+	l,_ := r.R.(*limitReader)
+	return l
+}
+
+// Enters a nested value. Must be paired with leave().
+func enter(r preciseio.PreciseReader) (*limitReader,error) {
+	l := limitsOf(r)
+	if l==nil { return nil,nil }
+	l.st.depth++
+	if l.st.MaxDepth>0 && l.st.depth>l.st.MaxDepth {
+		l.st.depth--
+		return nil,&LimitError{"MaxDepth",int64(l.st.MaxDepth)}
+	}
+	return l,nil
+}
+func (l *limitReader) leave() {
+	if l!=nil { l.st.depth-- }
+}
+
+// Accounts for n slice- or map-elements.
+func (l *limitReader) elements(n int) error {
+	if l==nil { return nil }
+	l.st.elems += int64(n)
+	max := l.st.MaxElements
+	if max==0 { max = l.st.MaxBytes }
+	if max>0 && l.st.elems>max { return &LimitError{"MaxElements",max} }
+	return nil
+}
+
+// Checks, that n more bytes can be consumed.
+func (l *limitReader) fits(n int) error {
+	if l!=nil && l.count && l.st.MaxBytes>0 && int64(n)>l.st.MaxBytes-l.st.bytes { return &LimitError{"MaxBytes",l.st.MaxBytes} }
+	return nil
+}
+
+// Like r.ReadBlob(), but checks the length against MaxBytes, before allocating.
+func readBlob(r preciseio.PreciseReader) ([]byte,error) {
+	return readBlobInto(r,nil)
+}
+
+// Like readBlob(). If the decoder reuses values, the blob is read into buf, if it fits.
+// If the decoder aliases its input, the blob is a sub-slice of the input.
+func readBlobInto(r preciseio.PreciseReader,buf []byte) ([]byte,error) {
+	l := limitsOf(r)
+	if l==nil { return r.ReadBlob() }
+	n,e := r.ReadListLength()
+	if e!=nil { return nil,e }
+	if e = l.fits(n); e!=nil { return nil,e }
+	if n==0 {
+		if l.st.reuse && buf!=nil { return buf[:0],nil }
+		return nil,nil
+	}
+	if s,ok := l.r.(*sliceReader); ok && l.st.alias {
+		if e = l.consume(n); e!=nil { return nil,e }
+		return s.next(n)
+	}
+	var b []byte
+	if l.st.reuse && cap(buf)>=n {
+		b = buf[:n]
+	} else {
+		b = make([]byte,n)
+	}
+	_,e = io.ReadFull(r.R,b)
+	if e!=nil { return nil,e }
+	return b,nil
+}
+
+// Reads an embedded blob using ce. The blob must be consumed completely.
+// The reader inherits the limits of r.
+func readEmbedded(ce CodecElement, r preciseio.PreciseReader, blob []byte, v reflect.Value) error {
+	sr := &sliceReader{b:blob}
+	pr := preciseio.PreciseReader{R:sr}
+	if l := limitsOf(r); l!=nil { pr.R = &limitReader{sr,l.st,false} }
+	if e := ce.Read(pr,v); e!=nil { return e }
+	if n := len(sr.b)-sr.i; n>0 { return errLeftOver(n) }
+	return nil
+}
+func errLeftOver(n int) error {
+	return fmt.Errorf("serializer: %d bytes left over in embedded value",n)
+}
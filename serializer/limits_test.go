@@ -0,0 +1,86 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "github.com/byte-mug/golibs/preciseio"
+import "bytes"
+import "testing"
+
+func limitErr(e error) string {
+	if le,ok := e.(*LimitError); ok { return le.Limit }
+	return ""
+}
+
+type blobMsg struct{
+	B []byte
+}
+
+type nestedArrays struct{
+	A [1][1][1]int
+}
+
+func TestLimits(t *testing.T) {
+	sw := Switch(0).AddType(1,[]int(nil))
+	ptr3 := ForType((***int)(nil))
+	for _,c := range []struct{
+		name  string
+		ce    CodecElement
+		data  []byte
+		lim   Limits
+		limit string // The expected LimitError.Limit, "" for success.
+	}{
+		{"blob",ForStruct(new(blobMsg)),[]byte{0xff,0xff,0xff,0x3f},Limits{MaxBytes:100},"MaxBytes"},
+		{"blob fits",ForStruct(new(blobMsg)),[]byte{0xff,2,1,2},Limits{MaxBytes:4},""},
+		{"bytes",ForType([]int(nil)),[]byte{3,2,4,6},Limits{MaxBytes:3},"MaxBytes"},
+		{"slice",ForType([]int(nil)),[]byte{3,2,4,6},Limits{MaxElements:2},"MaxElements"},
+		{"slice fits",ForType([]int(nil)),[]byte{3,2,4,6},Limits{MaxElements:3},""},
+		{"map",ForType(map[int]int(nil)),[]byte{0xff,2,2,4,6,8},Limits{MaxElements:1},"MaxElements"},
+		{"nested elements",ForType([][]int(nil)),[]byte{2,1,0,1,0},Limits{MaxElements:3},"MaxElements"},
+		{"zero-size elements",ForType([]struct{}(nil)),[]byte{0xff,0xff,0xff,0x07},Limits{MaxBytes:1<<20},"MaxElements"},
+		{"pointers",ptr3,[]byte{0xff,0xff,0xff,2},Limits{MaxDepth:2},"MaxDepth"},
+		{"pointers fit",ptr3,[]byte{0xff,0xff,0xff,2},Limits{MaxDepth:3},""},
+		{"arrays",ForStruct(new(nestedArrays)),[]byte{0xff,2},Limits{MaxDepth:3},"MaxDepth"},
+		{"arrays fit",ForStruct(new(nestedArrays)),[]byte{0xff,2},Limits{MaxDepth:4},""},
+		{"type switch",sw,[]byte{1,1,2},Limits{MaxDepth:1},"MaxDepth"},
+		{"type switch fits",sw,[]byte{1,1,2},Limits{MaxDepth:2},""},
+	} {
+		r := c.lim.Wrap(preciseio.PreciseReader{R:bytes.NewReader(c.data)})
+		_,e := Deserialize(c.ce,r)
+		if limitErr(e)!=c.limit || c.limit=="" && e!=nil { t.Errorf("%s: got %v, want %q",c.name,e,c.limit) }
+		
+		r = c.lim.Wrap(preciseio.PreciseReader{R:bytes.NewReader(c.data)})
+		e = Validate(c.ce,r)
+		if limitErr(e)!=c.limit || c.limit=="" && e!=nil { t.Errorf("%s: Validate: got %v, want %q",c.name,e,c.limit) }
+	}
+}
+
+func TestLimitsDepthRestored(t *testing.T) {
+	// The depth is released after each value, so siblings don't add up.
+	ce := ForType([]*int(nil))
+	data := []byte{3,0xff,2,0xff,4,0xff,6}
+	r := Limits{MaxDepth:2}.Wrap(preciseio.PreciseReader{R:bytes.NewReader(data)})
+	if _,e := Deserialize(ce,r); e!=nil { t.Fatal(e) }
+	if st := limitsOf(r).st; st.depth!=0 || st.elems!=3 || st.bytes!=int64(len(data)) { t.Errorf("state = %+v",*st) }
+}
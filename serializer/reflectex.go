@@ -25,6 +25,11 @@ SOFTWARE.
 package serializer
 
 import "reflect"
+import "bytes"
+import "math"
+import "sort"
+import "time"
+import "errors"
 import "github.com/byte-mug/golibs/preciseio"
 
 type CodecElement interface{
@@ -34,7 +39,9 @@ type CodecElement interface{
 
 type ceBlob struct{}
 func (ce ceBlob) Read(r preciseio.PreciseReader,v reflect.Value) error {
-	b,e := r.ReadBlob()
+	var old []byte
+	if v.Kind()==reflect.Slice { old = v.Bytes() }
+	b,e := readBlobInto(r,old)
 	if e!=nil { return e }
 	v.SetBytes(b)
 	return nil
@@ -45,7 +52,7 @@ func (ce ceBlob) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
 
 type ceString struct{}
 func (ce ceString) Read(r preciseio.PreciseReader,v reflect.Value) error {
-	b,e := r.ReadBlob()
+	b,e := readBlob(r)
 	if e!=nil { return e }
 	v.SetString(string(b))
 	return nil
@@ -100,15 +107,144 @@ func (ce ceSbyte) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
 	return w.W.WriteByte(byte(i))
 }
 
+type ceBool struct{}
+func (ce ceBool) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	b,e := r.R.ReadByte()
+	if e!=nil { return e }
+	v.SetBool(b!=0)
+	return nil
+}
+func (ce ceBool) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
+	b := byte(0)
+	if v.Bool() { b = 0xff }
+	return w.W.WriteByte(b)
+}
+
+// Floats are encoded as their IEEE 754 bits (big-endian, 4 or 8 bytes).
+type ceFloat32 struct{}
+func (ce ceFloat32) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	i,e := r.ReadUint32()
+	if e!=nil { return e }
+	v.SetFloat(float64(math.Float32frombits(i)))
+	return nil
+}
+func (ce ceFloat32) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
+	return w.WriteUint32(math.Float32bits(float32(v.Float())))
+}
+
+type ceFloat64 struct{}
+func (ce ceFloat64) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	i,e := r.ReadUint64()
+	if e!=nil { return e }
+	v.SetFloat(math.Float64frombits(i))
+	return nil
+}
+func (ce ceFloat64) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
+	return w.WriteUint64(math.Float64bits(v.Float()))
+}
+
+// Complex numbers are encoded as two floats: the real part followed by the imaginary part.
+type ceComplex64 struct{}
+func (ce ceComplex64) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	rp,e := r.ReadUint32()
+	if e!=nil { return e }
+	ip,e := r.ReadUint32()
+	if e!=nil { return e }
+	v.SetComplex(complex(float64(math.Float32frombits(rp)),float64(math.Float32frombits(ip))))
+	return nil
+}
+func (ce ceComplex64) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
+	c := v.Complex()
+	e := w.WriteUint32(math.Float32bits(float32(real(c))))
+	if e!=nil { return e }
+	return w.WriteUint32(math.Float32bits(float32(imag(c))))
+}
+
+type ceComplex128 struct{}
+func (ce ceComplex128) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	rp,e := r.ReadUint64()
+	if e!=nil { return e }
+	ip,e := r.ReadUint64()
+	if e!=nil { return e }
+	v.SetComplex(complex(math.Float64frombits(rp),math.Float64frombits(ip)))
+	return nil
+}
+func (ce ceComplex128) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
+	c := v.Complex()
+	e := w.WriteUint64(math.Float64bits(real(c)))
+	if e!=nil { return e }
+	return w.WriteUint64(math.Float64bits(imag(c)))
+}
+
+var tpTime = reflect.TypeOf(time.Time{})
+
+// A time.Time is encoded in UTC as seconds since the unix epoch (varint)
+// followed by the nanoseconds within the second (uvarint).
+// The location and the monotonic clock reading are not preserved,
+// so a decoded time.Time is always in UTC.
+// Nanoseconds of 1e9 or more are rejected with EInvalidTime, so every time.Time has a single encoding.
+type ceTime struct{}
+var EInvalidTime = errors.New("serializer: nanoseconds out of range")
+func readTime(r preciseio.PreciseReader) (time.Time,error) {
+	s,e := r.ReadVarint()
+	if e!=nil { return time.Time{},e }
+	ns,e := r.ReadUvarint()
+	if e!=nil { return time.Time{},e }
+	if ns>=1e9 { return time.Time{},EInvalidTime }
+	return time.Unix(s,int64(ns)).UTC(),nil
+}
+func (ce ceTime) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	t,e := readTime(r)
+	if e!=nil { return e }
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
+func (ce ceTime) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
+	t := CastV(tpTime,v).Interface().(time.Time)
+	e := w.WriteVarint(t.Unix())
+	if e!=nil { return e }
+	return w.WriteUvarint(uint64(t.Nanosecond()))
+}
+
 type ceSlice struct{
 	child CodecElement
 	t reflect.Type
 }
 func (ce ceSlice) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	l,e := enter(r)
+	if e!=nil { return e }
+	defer l.leave()
 	n,e := r.ReadListLength()
 	if e!=nil { return e }
+	reuse := l.reusing() && v.Type()==ce.t && !v.IsNil()
 	if n==0 {
-		v.Set(reflect.Zero(v.Type()))
+		if reuse {
+			v.SetLen(0)
+		} else {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	if e = l.elements(n); e!=nil { return e }
+	if reuse && n<=v.Cap() {
+		// Decode into the existing elements.
+		v.SetLen(n)
+		for i:=0; i<n; i++ {
+			e = ce.child.Read(r,v.Index(i))
+			if e!=nil { return e }
+		}
+		return nil
+	}
+	if n>maxPrealloc {
+		// Don't trust n: grow the slice as elements arrive.
+		nv := reflect.MakeSlice(ce.t,0,maxPrealloc)
+		ez := reflect.Zero(ce.t.Elem())
+		for i:=0; i<n; i++ {
+			nv = reflect.Append(nv,ez)
+			e = ce.child.Read(r,nv.Index(i))
+			if e!=nil { return e }
+		}
+		v.Set(nv)
 		return nil
 	}
 	nv := reflect.MakeSlice(ce.t,n,n)
@@ -138,6 +274,9 @@ type ceMap struct{
 	t reflect.Type
 }
 func (ce ceMap) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	l,e := enter(r)
+	if e!=nil { return e }
+	defer l.leave()
 	b,e := r.R.ReadByte()
 	if e!=nil { return e }
 	if b==0 {
@@ -146,10 +285,28 @@ func (ce ceMap) Read(r preciseio.PreciseReader,v reflect.Value) error {
 	}
 	n,e := r.ReadListLength()
 	if e!=nil { return e }
-	nv := reflect.MakeMapWithSize(ce.t,n)
+	if e = l.elements(n); e!=nil { return e }
+	reuse := l.reusing()
+	var nv reflect.Value
+	if reuse && v.Type()==ce.t && !v.IsNil() {
+		// Clear and refill the existing map.
+		nv = v
+		for it := nv.MapRange(); it.Next(); { nv.SetMapIndex(it.Key(),reflect.Value{}) }
+		// NaN keys can't be deleted, so such a map is replaced.
+		if nv.Len()>0 { nv = reflect.MakeMapWithSize(ce.t,0) }
+	} else {
+		hint := n
+		if hint>maxPrealloc { hint = maxPrealloc }
+		nv = reflect.MakeMapWithSize(ce.t,hint)
+	}
 	ckv := reflect.New(ce.t.Key()).Elem()
 	cvv := reflect.New(ce.t.Elem()).Elem()
 	for i:=0 ; i<n; i++ {
+		if reuse && i>0 {
+			// Don't reuse the storage of the previous entry.
+			ckv.Set(reflect.Zero(ce.t.Key()))
+			cvv.Set(reflect.Zero(ce.t.Elem()))
+		}
 		e = ce.k.Read(r,ckv)
 		if e!=nil { return e }
 		e = ce.v.Read(r,cvv)
@@ -164,24 +321,59 @@ func (ce ceMap) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
 	if v.IsNil() { return w.W.WriteByte(0) }
 	e := w.W.WriteByte(0xff)
 	if e!=nil { return e }
-	keys := v.MapKeys()
-	n := len(keys)
+	// Collect keys and values together: MapIndex() can't find NaN keys.
+	n := v.Len()
+	keys := make([]reflect.Value,0,n)
+	vals := make([]reflect.Value,0,n)
+	for it := v.MapRange(); it.Next(); {
+		keys = append(keys,it.Key())
+		vals = append(vals,it.Value())
+	}
 	e = w.WriteListLength(n)
 	if e!=nil { return e }
-	for _,key := range keys {
-		e = ce.k.Write(w,key)
+	
+	/*
+	 * Go's map iteration order is random. In order to serialize maps
+	 * deterministically, the entries are sorted by their encoded keys.
+	 */
+	ents := make([]mapEntry,n)
+	buf := new(bytes.Buffer)
+	kw := preciseio.PreciseWriterFromPool()
+	defer kw.PutToPool()
+	kw.W = buf
+	offs := make([]int,n+1)
+	for i,key := range keys {
+		e = ce.k.Write(kw,key)
 		if e!=nil { return e }
-		e = ce.v.Write(w,v.MapIndex(key))
+		offs[i+1] = buf.Len()
+	}
+	kbuf := buf.Bytes()
+	for i := range keys {
+		ents[i] = mapEntry{vals[i],kbuf[offs[i]:offs[i+1]]}
+	}
+	sort.Slice(ents,func(i,j int) bool { return bytes.Compare(ents[i].enc,ents[j].enc)<0 })
+	for _,ent := range ents {
+		_,e = w.W.Write(ent.enc)
+		if e!=nil { return e }
+		e = ce.v.Write(w,ent.val)
 		if e!=nil { return e }
 	}
 	return nil
 }
 
+type mapEntry struct{
+	val reflect.Value
+	enc []byte // The encoded key.
+}
+
 type ceArray struct{
 	child CodecElement
 	t reflect.Type
 }
 func (ce ceArray) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	l,e := enter(r)
+	if e!=nil { return e }
+	defer l.leave()
 	n := ce.t.Len()
 	nv := v
 	wrongtype := v.Type()!=ce.t // Type-mismatch
@@ -210,12 +402,16 @@ type cePtr struct{
 	t reflect.Type
 }
 func (ce cePtr) Read(r preciseio.PreciseReader,v reflect.Value) error {
+	l,e := enter(r)
+	if e!=nil { return e }
+	defer l.leave()
 	b,e := r.R.ReadByte()
 	if e!=nil { return e }
 	if b==0 {
 		v.Set(reflect.Zero(v.Type()))
 		return nil
 	}
+	if l.reusing() && v.Type()==ce.t && !v.IsNil() { return ce.child.Read(r,v.Elem()) }
 	pv := reflect.New(ce.t.Elem())
 	v.Set(pv)
 	return ce.child.Read(r,pv.Elem())
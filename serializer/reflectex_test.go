@@ -0,0 +1,74 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "github.com/byte-mug/golibs/preciseio"
+import "bytes"
+import "math"
+import "reflect"
+import "testing"
+import "time"
+
+type scalars struct{
+	B   bool
+	F32 float32
+	F64 float64
+	C64 complex64
+	C   complex128
+	T   time.Time
+}
+
+func TestScalars(t *testing.T) {
+	ce := ForStruct(new(scalars))
+	for _,v := range []*scalars{
+		{},
+		{true,1.5,-math.MaxFloat64,complex(1,-2),complex(math.Inf(1),0.25),time.Unix(1e9,999999999).UTC()},
+		{T:time.Date(1800,1,2,3,4,5,6,time.UTC)},
+	} {
+		r,e := decode(ce,encode(t,ce,v))
+		if e!=nil { t.Fatal(e) }
+		if !reflect.DeepEqual(r,v) { t.Errorf("got %+v, want %+v",r,v) }
+	}
+	
+	// Times are decoded in UTC.
+	loc := time.FixedZone("X",3600)
+	in := time.Date(2020,1,1,12,0,0,5,loc)
+	r,e := decode(ForType(in),encode(t,ForType(in),in))
+	if e!=nil { t.Fatal(e) }
+	if out := r.(time.Time); !out.Equal(in) || out.Location()!=time.UTC { t.Errorf("got %v, want %v",out,in) }
+}
+
+func TestTimeNanosecondRange(t *testing.T) {
+	ce := ForType(time.Time{})
+	for _,data := range [][]byte{
+		{0x02,0x80,0x94,0xeb,0xdc,0x03}, // 1s + 1e9ns
+		{0x00,0xff,0xff,0xff,0xff,0x0f},
+	} {
+		if _,e := decode(ce,data); e!=EInvalidTime { t.Errorf("%x: got %v, want EInvalidTime",data,e) }
+		if e := Validate(ce,preciseio.PreciseReader{R:bytes.NewReader(data)}); e!=EInvalidTime { t.Errorf("Validate %x: got %v",data,e) }
+	}
+	r,e := decode(ce,[]byte{0x02,0xff,0x93,0xeb,0xdc,0x03}) // 1s + 999999999ns
+	if e!=nil || !r.(time.Time).Equal(time.Unix(1,999999999)) { t.Errorf("got %v, %v",r,e) }
+}
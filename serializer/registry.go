@@ -0,0 +1,237 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "fmt"
+import "reflect"
+import "sync"
+import "github.com/byte-mug/golibs/preciseio"
+
+// Returned by Registry.Read, if the type tag is not registered.
+type UnknownTagError struct{
+	ID   uint64 // The tag of a registry created by NewRegistry().
+	Name string // The tag of a registry created by NewNamedRegistry(). Validate() reports at most 512 bytes of it.
+}
+func (e *UnknownTagError) Error() string {
+	if e.Name!="" { return fmt.Sprintf("serializer: unknown type name %q",e.Name) }
+	return fmt.Sprintf("serializer: unknown type id %d",e.ID)
+}
+
+// Returned by Registry.Write, if the type of the value is not registered.
+type UnregisteredTypeError struct{
+	Type reflect.Type
+}
+func (e *UnregisteredTypeError) Error() string {
+	return fmt.Sprintf("serializer: unregistered type %v",e.Type)
+}
+
+type registryItem struct{
+	id   uint64
+	name string
+	t    reflect.Type
+	ce   CodecElement
+}
+
+/*
+A polymorphic codec, like TypeSwitch, but without the limit of 255 types.
+Each registered type is identified by either a numeric id (encoded as uvarint)
+or by a name (encoded as blob). A nil value is encoded as id 0 or as empty name.
+
+Types can be registered at any time, even after the codec is in use.
+
+	var shapes = serializer.NewNamedRegistry().Bind((*Shape)(nil))
+
+	func init() {
+		shapes.RegisterName("circle",Circle{})
+	}
+
+Unlike TypeSwitch, unknown tags and unregistered types are reported as
+*UnknownTagError and *UnregisteredTypeError.
+*/
+type Registry struct{
+	named  bool
+	maxLen int // The length of the longest registered name.
+	iface  reflect.Type
+	lock   sync.RWMutex
+	byID   map[uint64]*registryItem
+	byName map[string]*registryItem
+	byType map[reflect.Type]*registryItem
+}
+
+// Creates a Registry using numeric ids as tags.
+func NewRegistry() *Registry {
+	return &Registry{byID:make(map[uint64]*registryItem),byType:make(map[reflect.Type]*registryItem)}
+}
+// Creates a Registry using type names as tags.
+func NewNamedRegistry() *Registry {
+	return &Registry{named:true,byName:make(map[string]*registryItem),byType:make(map[reflect.Type]*registryItem)}
+}
+
+var ifaceRegistryLock sync.RWMutex
+var ifaceRegistry = make(map[reflect.Type]*Registry)
+
+/*
+Binds the Registry to the interface type *ip, which must be a pointer to an interface.
+Every struct field (or slice element, map value, ...) of that interface type
+is then serialized using this Registry:
+
+	type Drawing struct{
+		Shapes []Shape `serializer:"1"`
+	}
+	var ser_Drawing = serializer.ForStruct(new(Drawing))
+
+The Registry must be bound before the codecs using the interface are built.
+All types registered must implement the interface.
+*/
+func (r *Registry) Bind(ip interface{}) *Registry {
+	tp := reflect.TypeOf(ip)
+	if tp==nil || tp.Kind()!=reflect.Ptr || tp.Elem().Kind()!=reflect.Interface { panic(fmt.Sprintf("Required *interface{}, but got %v",tp)) }
+	tp = tp.Elem()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.iface!=nil { panic(fmt.Sprintf("Registry is already bound to %v",r.iface)) }
+	for t := range r.byType {
+		if !t.Implements(tp) { panic(fmt.Sprintf("Type %v does not implement %v",t,tp)) }
+	}
+	ifaceRegistryLock.Lock()
+	defer ifaceRegistryLock.Unlock()
+	if _,ok := ifaceRegistry[tp]; ok { panic(fmt.Sprintf("Interface %v is already bound",tp)) }
+	ifaceRegistry[tp] = r
+	r.iface = tp
+	return r
+}
+
+// Obtains the Registry bound to the interface type t, if any.
+func boundRegistry(t reflect.Type) *Registry {
+	ifaceRegistryLock.RLock()
+	defer ifaceRegistryLock.RUnlock()
+	return ifaceRegistry[t]
+}
+
+func (r *Registry) add(i *registryItem) *Registry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.iface!=nil && !i.t.Implements(r.iface) { panic(fmt.Sprintf("Type %v does not implement %v",i.t,r.iface)) }
+	if _,ok := r.byType[i.t]; ok { panic(fmt.Sprintf("Type-Conflict: %v is already registered.",i.t)) }
+	if r.named {
+		if _,ok := r.byName[i.name]; ok { panic(fmt.Sprintf("Name-Conflict: name %q is already in use.",i.name)) }
+		r.byName[i.name] = i
+		if len(i.name)>r.maxLen { r.maxLen = len(i.name) }
+	} else {
+		if _,ok := r.byID[i.id]; ok { panic(fmt.Sprintf("Name-Conflict: id %d is already in use.",i.id)) }
+		r.byID[i.id] = i
+	}
+	r.byType[i.t] = i
+	return r
+}
+func (r *Registry) addID(id uint64,tp reflect.Type,ce CodecElement) *Registry {
+	if r.named { panic("Registry uses names, not ids") }
+	if id==0 { panic("id 0 is reserved for nil") }
+	return r.add(&registryItem{id:id,t:tp,ce:ce})
+}
+func (r *Registry) addName(name string,tp reflect.Type,ce CodecElement) *Registry {
+	if !r.named { panic("Registry uses ids, not names") }
+	if name=="" { panic("The empty name is reserved for nil") }
+	return r.add(&registryItem{name:name,t:tp,ce:ce})
+}
+
+// Registers reflect.TypeOf(i) under the given id.
+func (r *Registry) Register(id uint64,i interface{}) *Registry {
+	tp := reflect.TypeOf(i)
+	ce := serializerFor(tp)
+	if ce==nil { panic(fmt.Sprintf("Registry %d: non-supported type: %v",id,tp)) }
+	return r.addID(id,tp,ce)
+}
+// Registers reflect.TypeOf(i) under the given id, using ce as serializer.
+func (r *Registry) RegisterWith(id uint64,i interface{}, ce CodecElement) *Registry {
+	if ce==nil { panic("ce must not be <nil>") }
+	return r.addID(id,reflect.TypeOf(i),ce)
+}
+// Registers reflect.TypeOf(i) under the given name.
+func (r *Registry) RegisterName(name string,i interface{}) *Registry {
+	tp := reflect.TypeOf(i)
+	ce := serializerFor(tp)
+	if ce==nil { panic(fmt.Sprintf("Registry %q: non-supported type: %v",name,tp)) }
+	return r.addName(name,tp,ce)
+}
+// Registers reflect.TypeOf(i) under the given name, using ce as serializer.
+func (r *Registry) RegisterNameWith(name string,i interface{}, ce CodecElement) *Registry {
+	if ce==nil { panic("ce must not be <nil>") }
+	return r.addName(name,reflect.TypeOf(i),ce)
+}
+
+func (r *Registry) Read(pr preciseio.PreciseReader,v reflect.Value) error {
+	l,e := enter(pr)
+	if e!=nil { return e }
+	defer l.leave()
+	var i *registryItem
+	if r.named {
+		b,e := readBlob(pr)
+		if e!=nil { return e }
+		if len(b)==0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		r.lock.RLock()
+		i = r.byName[string(b)]
+		r.lock.RUnlock()
+		if i==nil { return &UnknownTagError{Name:string(b)} }
+	} else {
+		id,e := pr.ReadUvarint()
+		if e!=nil { return e }
+		if id==0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		r.lock.RLock()
+		i = r.byID[id]
+		r.lock.RUnlock()
+		if i==nil { return &UnknownTagError{ID:id} }
+	}
+	nv := reflect.New(i.t).Elem()
+	e = i.ce.Read(pr,nv)
+	if e!=nil { return e }
+	v.Set(nv)
+	return nil
+}
+func (r *Registry) Write(w *preciseio.PreciseWriter,v reflect.Value) error {
+	dv := reflect.ValueOf(GetInterface(v))
+	if !dv.IsValid() {
+		if r.named { return w.WriteBlob(nil) }
+		return w.WriteUvarint(0)
+	}
+	r.lock.RLock()
+	i := r.byType[dv.Type()]
+	r.lock.RUnlock()
+	if i==nil { return &UnregisteredTypeError{dv.Type()} }
+	var e error
+	if r.named {
+		e = w.WriteBlob([]byte(i.name))
+	} else {
+		e = w.WriteUvarint(i.id)
+	}
+	if e!=nil { return e }
+	return i.ce.Write(w,dv)
+}
@@ -0,0 +1,85 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "reflect"
+import "testing"
+
+type testShape interface{ Area() int }
+type testSquare struct{ Side int }
+type testRect struct{ W,H int }
+func (s testSquare) Area() int { return s.Side*s.Side }
+func (r *testRect) Area() int { return r.W*r.H }
+
+var testShapes = NewNamedRegistry().Bind((*testShape)(nil)).
+	RegisterName("square",testSquare{}).
+	RegisterName("rect",&testRect{})
+
+type drawing struct{
+	Shapes []testShape
+	Main   testShape
+}
+
+func TestRegistryBound(t *testing.T) {
+	ce := ForStruct(new(drawing))
+	v := &drawing{[]testShape{testSquare{2},&testRect{2,3},nil},testSquare{1}}
+	data := encode(t,ce,v)
+	r,e := decode(ce,data)
+	if e!=nil { t.Fatal(e) }
+	if !reflect.DeepEqual(r,v) { t.Errorf("got %+v, want %+v",r,v) }
+	if e := Validate(ce,reader(data)); e!=nil { t.Errorf("Validate: %v",e) }
+}
+
+func TestRegistryIDs(t *testing.T) {
+	reg := NewRegistry().Register(1,"").Register(300,int64(0)).Register(2,[]string(nil))
+	ce := ForContainerWith([]interface{}(nil),reg)
+	v := []interface{}{"x",int64(-5),nil,[]string{"a"}}
+	data := encode(t,ce,v)
+	if want := []byte{4, 1,1,'x', 0xac,0x02,9, 0, 2,1,1,'a'} ; string(data)!=string(want) { t.Errorf("got %x, want %x",data,want) }
+	r,e := decode(ce,data)
+	if e!=nil { t.Fatal(e) }
+	if !reflect.DeepEqual(r,v) { t.Errorf("got %#v, want %#v",r,v) }
+}
+
+func TestRegistryErrors(t *testing.T) {
+	reg := NewRegistry().Register(1,"")
+	if _,e := decode(reg,[]byte{7}); !reflect.DeepEqual(e,&UnknownTagError{ID:7}) { t.Errorf("unknown id: %v",e) }
+	if e := Validate(reg,reader([]byte{7})); !reflect.DeepEqual(e,&UnknownTagError{ID:7}) { t.Errorf("Validate unknown id: %v",e) }
+	
+	named := NewNamedRegistry().RegisterName("s","")
+	if _,e := decode(named,[]byte{1,'t'}); !reflect.DeepEqual(e,&UnknownTagError{Name:"t"}) { t.Errorf("unknown name: %v",e) }
+	if e := Validate(named,reader([]byte{1,'t'})); !reflect.DeepEqual(e,&UnknownTagError{Name:"t"}) { t.Errorf("Validate unknown name: %v",e) }
+	
+	e := Serialize(reg,newWriter(),1.5)
+	if ue,ok := e.(*UnregisteredTypeError); !ok || ue.Type!=reflect.TypeOf(1.5) { t.Errorf("unregistered: %v",e) }
+	
+	expectPanic(t,"id 0",func() { NewRegistry().Register(0,"") })
+	expectPanic(t,"empty name",func() { NewNamedRegistry().RegisterName("","") })
+	expectPanic(t,"duplicate id",func() { NewRegistry().Register(1,"").Register(1,0) })
+	expectPanic(t,"duplicate type",func() { NewRegistry().Register(1,"").Register(2,"") })
+	expectPanic(t,"name on id registry",func() { NewRegistry().RegisterName("x","") })
+	expectPanic(t,"not implemented",func() { testShapes.RegisterName("str","") })
+	expectPanic(t,"bound twice",func() { NewRegistry().Bind((*testShape)(nil)) })
+}
@@ -0,0 +1,275 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "io"
+import "sync"
+import "github.com/byte-mug/golibs/preciseio"
+
+/*
+Optionally implemented by a CodecElement, to skip over a value without decoding it.
+All built-in codecs implement this interface.
+
+If validate is false, the skipper may skip embedded blobs (such as the fields of
+versioned structures) without looking at their content. If validate is true,
+the value must be checked as thoroughly as Read() would check it.
+*/
+type Skipper interface{
+	Skip(r preciseio.PreciseReader, validate bool) error
+}
+
+// Skips over a value, without decoding it.
+func Skip(ce CodecElement, r preciseio.PreciseReader) error {
+	return skip(ce,r,false)
+}
+
+// Checks, that a value can be decoded (by ce.Read()), without decoding it. The value is consumed.
+func Validate(ce CodecElement, r preciseio.PreciseReader) error {
+	return skip(ce,r,true)
+}
+
+func skip(ce CodecElement, r preciseio.PreciseReader, validate bool) error {
+	if s,ok := ce.(Skipper); ok { return s.Skip(r,validate) }
+	_,e := opaqueRead(ce,r) // Fall back to decoding.
+	return e
+}
+
+var pool_skipBuf = sync.Pool{ New: func() interface{} { return new([512]byte) } }
+
+// Reads and discards n bytes.
+func discard(r preciseio.PreciseReader, n int) error {
+	if n==0 { return nil }
+	buf := pool_skipBuf.Get().(*[512]byte)
+	defer pool_skipBuf.Put(buf)
+	for n>0 {
+		m := n
+		if m>len(buf) { m = len(buf) }
+		_,e := io.ReadFull(r.R,buf[:m])
+		if e!=nil { return e }
+		n -= m
+	}
+	return nil
+}
+
+func skipBlob(r preciseio.PreciseReader) error {
+	n,e := r.ReadListLength()
+	if e!=nil { return e }
+	if e = limitsOf(r).fits(n); e!=nil { return e }
+	return discard(r,n)
+}
+func skipVarint(r preciseio.PreciseReader) error {
+	_,e := r.ReadUvarint()
+	return e
+}
+
+func (ce ceBlob) Skip(r preciseio.PreciseReader, validate bool) error { return skipBlob(r) }
+func (ce ceString) Skip(r preciseio.PreciseReader, validate bool) error { return skipBlob(r) }
+func (ce ceInt) Skip(r preciseio.PreciseReader, validate bool) error { return skipVarint(r) }
+func (ce ceUint) Skip(r preciseio.PreciseReader, validate bool) error { return skipVarint(r) }
+func (ce ceByte) Skip(r preciseio.PreciseReader, validate bool) error { return discard(r,1) }
+func (ce ceSbyte) Skip(r preciseio.PreciseReader, validate bool) error { return discard(r,1) }
+func (ce ceBool) Skip(r preciseio.PreciseReader, validate bool) error { return discard(r,1) }
+func (ce ceFloat32) Skip(r preciseio.PreciseReader, validate bool) error { return discard(r,4) }
+func (ce ceFloat64) Skip(r preciseio.PreciseReader, validate bool) error { return discard(r,8) }
+func (ce ceComplex64) Skip(r preciseio.PreciseReader, validate bool) error { return discard(r,8) }
+func (ce ceComplex128) Skip(r preciseio.PreciseReader, validate bool) error { return discard(r,16) }
+func (ce ceTime) Skip(r preciseio.PreciseReader, validate bool) error {
+	if validate {
+		_,e := readTime(r)
+		return e
+	}
+	if e := skipVarint(r); e!=nil { return e }
+	return skipVarint(r)
+}
+
+func (ce ceSlice) Skip(r preciseio.PreciseReader, validate bool) error {
+	l,e := enter(r)
+	if e!=nil { return e }
+	defer l.leave()
+	n,e := r.ReadListLength()
+	if e!=nil { return e }
+	if e = l.elements(n); e!=nil { return e }
+	for i:=0; i<n; i++ {
+		if e = skip(ce.child,r,validate); e!=nil { return e }
+	}
+	return nil
+}
+func (ce ceArray) Skip(r preciseio.PreciseReader, validate bool) error {
+	l,e := enter(r)
+	if e!=nil { return e }
+	defer l.leave()
+	n := ce.t.Len()
+	for i:=0; i<n; i++ {
+		if e := skip(ce.child,r,validate); e!=nil { return e }
+	}
+	return nil
+}
+func (ce ceMap) Skip(r preciseio.PreciseReader, validate bool) error {
+	l,e := enter(r)
+	if e!=nil { return e }
+	defer l.leave()
+	b,e := r.R.ReadByte()
+	if e!=nil || b==0 { return e }
+	n,e := r.ReadListLength()
+	if e!=nil { return e }
+	if e = l.elements(n); e!=nil { return e }
+	for i:=0; i<n; i++ {
+		if e = skip(ce.k,r,validate); e!=nil { return e }
+		if e = skip(ce.v,r,validate); e!=nil { return e }
+	}
+	return nil
+}
+func (ce cePtr) Skip(r preciseio.PreciseReader, validate bool) error {
+	l,e := enter(r)
+	if e!=nil { return e }
+	defer l.leave()
+	b,e := r.R.ReadByte()
+	if e!=nil || b==0 { return e }
+	return skip(ce.child,r,validate)
+}
+func (ce ceStripawayPtr) Skip(r preciseio.PreciseReader, validate bool) error { return skip(ce.child,r,validate) }
+func (ce ceAddPtr) Skip(r preciseio.PreciseReader, validate bool) error { return skip(ce.child,r,validate) }
+func (l *ceLazy) Skip(r preciseio.PreciseReader, validate bool) error { return skip(l.get(),r,validate) }
+func (c structFieldsCodec) Skip(r preciseio.PreciseReader, validate bool) error { return c.s.skipFields(r,validate) }
+
+func (s *StructBuilder) Skip(r preciseio.PreciseReader, validate bool) error {
+	l,e := enter(r)
+	if e!=nil { return e }
+	defer l.leave()
+	if !s.noptr {
+		b,e := r.R.ReadByte()
+		if e!=nil || b==0 { return e }
+	}
+	return s.skipFields(r,validate)
+}
+func (s *StructBuilder) skipFields(r preciseio.PreciseReader, validate bool) error {
+	if !s.versioned {
+		for _,field := range s.fields {
+			if e := skip(field.ce,r,validate); e!=nil { return e }
+		}
+		return nil
+	}
+	for {
+		id,e := r.ReadUvarint()
+		if e!=nil || id==0 { return e }
+		n,e := r.ReadListLength()
+		if e!=nil { return e }
+		i,ok := s.byID[id]
+		if !validate || !ok {
+			if e = discard(r,n); e!=nil { return e }
+			continue
+		}
+		if e = skipBounded(s.fields[i].ce,r,n); e!=nil { return e }
+	}
+}
+
+// A reader, that is limited to the n bytes of an embedded blob.
+type boundReader struct{
+	r preciseio.Reader
+	n int
+	l limitReader
+}
+func (b *boundReader) Read(p []byte) (int,error) {
+	if b.n<=0 { return 0,io.EOF }
+	if len(p)>b.n { p = p[:b.n] }
+	n,e := b.r.Read(p)
+	b.n -= n
+	return n,e
+}
+func (b *boundReader) ReadByte() (byte,error) {
+	if b.n<=0 { return 0,io.EOF }
+	c,e := b.r.ReadByte()
+	if e==nil { b.n-- }
+	return c,e
+}
+var pool_boundReader = sync.Pool{ New: func() interface{} { return new(boundReader) } }
+
+// Validates a value embedded in a blob of n bytes, which must be consumed completely.
+func skipBounded(ce CodecElement, r preciseio.PreciseReader, n int) error {
+	br := pool_boundReader.Get().(*boundReader)
+	defer pool_boundReader.Put(br)
+	br.r,br.n = r.R,n
+	sub := preciseio.PreciseReader{R:br}
+	if l := limitsOf(r); l!=nil {
+		br.l = limitReader{br,l.st,false}
+		sub.R = &br.l
+	}
+	e := skip(ce,sub,true)
+	if e==io.EOF { e = io.ErrUnexpectedEOF }
+	if e==nil && br.n>0 { e = errLeftOver(br.n) }
+	br.r,br.l = nil,limitReader{}
+	return e
+}
+
+func (t *TypeSwitch) Skip(r preciseio.PreciseReader, validate bool) error {
+	l,e := enter(r)
+	if e!=nil { return e }
+	defer l.leave()
+	b,e := r.R.ReadByte()
+	if e!=nil { return e }
+	i,ok := t.oth[b]
+	if !ok { return nil } // Decoded as nil.
+	return skip(i.ce,r,validate)
+}
+
+func (r *Registry) Skip(pr preciseio.PreciseReader, validate bool) error {
+	l,e := enter(pr)
+	if e!=nil { return e }
+	defer l.leave()
+	var i *registryItem
+	if r.named {
+		n,e := pr.ReadListLength()
+		if e!=nil || n==0 { return e }
+		if e = limitsOf(pr).fits(n); e!=nil { return e }
+		buf := pool_skipBuf.Get().(*[512]byte)
+		defer pool_skipBuf.Put(buf)
+		r.lock.RLock()
+		long := n>r.maxLen
+		r.lock.RUnlock()
+		if long {
+			// No registered name is that long, so don't buffer it.
+			m := n
+			if m>len(buf) { m = len(buf) }
+			if _,e = io.ReadFull(pr.R,buf[:m]); e!=nil { return e }
+			if e = discard(pr,n-m); e!=nil { return e }
+			return &UnknownTagError{Name:string(buf[:m])}
+		}
+		var name []byte
+		if n<=len(buf) { name = buf[:n] } else { name = make([]byte,n) } // Bounded by the registered names.
+		if _,e = io.ReadFull(pr.R,name); e!=nil { return e }
+		r.lock.RLock()
+		i = r.byName[string(name)]
+		r.lock.RUnlock()
+		if i==nil { return &UnknownTagError{Name:string(name)} }
+	} else {
+		id,e := pr.ReadUvarint()
+		if e!=nil || id==0 { return e }
+		r.lock.RLock()
+		i = r.byID[id]
+		r.lock.RUnlock()
+		if i==nil { return &UnknownTagError{ID:id} }
+	}
+	return skip(i.ce,pr,validate)
+}
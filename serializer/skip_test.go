@@ -0,0 +1,110 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "github.com/byte-mug/golibs/preciseio"
+import "bytes"
+import "strings"
+import "testing"
+import "time"
+
+func TestSkipConsumesValue(t *testing.T) {
+	n := 5
+	full := &jsonMsg{-1,2,"s",[]byte{1},1.5,2.5,3i,time.Unix(5,6),&n,map[string]int{"a":1,"b":2},map[int]string{1:"x"},
+		[2]bool{true,false},[][]int8{{1},nil},&testRect{2,3},&recordV2{ID:1,Email:"e",Tags:[]string{"t"}}}
+	for _,c := range []struct{
+		name string
+		ce   CodecElement
+		v    interface{}
+	}{
+		{"struct",ForStruct(new(jsonMsg)),full},
+		{"empty struct",ForStruct(new(jsonMsg)),&jsonMsg{}},
+		{"nil struct",ForStruct(new(jsonMsg)),(*jsonMsg)(nil)},
+		{"versioned",ForStructVersioned(new(recordV2)),&recordV2{ID:1,Data:[]byte("data")}},
+		{"registry",ForStruct(new(drawing)),&drawing{[]testShape{testSquare{2},nil},&testRect{1,1}}},
+		{"type switch",Switch(0).AddType(1,[]int(nil)),[]int{1,2}},
+		{"lazy",Lazy(func() CodecElement { return ForType([]string(nil)) }),[]string{"a","b"}},
+	} {
+		data := append(encode(t,c.ce,c.v),0xee)
+		for _,f := range []struct{
+			name string
+			f    func(CodecElement,preciseio.PreciseReader) error
+		}{
+			{"Skip",Skip},
+			{"Validate",Validate},
+			{"Read",func(ce CodecElement,r preciseio.PreciseReader) error { _,e := Deserialize(ce,r); return e }},
+		} {
+			br := bytes.NewReader(data)
+			if e := f.f(c.ce,preciseio.PreciseReader{R:br}); e!=nil { t.Errorf("%s: %s: %v",c.name,f.name,e); continue }
+			if br.Len()!=1 { t.Errorf("%s: %s left %d bytes, want 1",c.name,f.name,br.Len()) }
+		}
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	shape := ForType(new(testShape)) // A pointer: 0xff, followed by the name.
+	long := append([]byte{0xff,0xff,0xff,0x03},bytes.Repeat([]byte{'x'},0xffff)...)
+	for _,c := range []struct{
+		name string
+		ce   CodecElement
+		data []byte
+		err  string
+	}{
+		{"unknown name",shape,[]byte{0xff,4,'c','i','r','c'},`unknown type name "circ"`},
+		{"long name",shape,long,`unknown type name "xxx`},
+		{"short name",shape,[]byte{0xff,4,'s','q'},"EOF"},
+		{"time",ForType(time.Time{}),[]byte{0,0x80,0xa8,0xd6,0xb9,0x07},"nanoseconds"},
+		{"leftover",ForStructVersioned(new(recordV1)),[]byte{1,2,2,0,0},"left over"},
+	} {
+		e := Validate(c.ce,reader(c.data))
+		if e==nil || !strings.Contains(e.Error(),c.err) { t.Errorf("%s: got %v, want %q",c.name,e,c.err) }
+	}
+}
+
+func TestSkipNoAllocs(t *testing.T) {
+	ce := ForStruct(new(drawing))
+	data := encode(t,ce,&drawing{[]testShape{testSquare{2},&testRect{2,3}},testSquare{1}})
+	long := append([]byte{0xff,0xff,0xff,0x03},bytes.Repeat([]byte{'x'},0xffff)...)
+	br := new(bytes.Reader)
+	for _,c := range []struct{
+		name string
+		ce   CodecElement
+		data []byte
+		max  float64
+	}{
+		{"registry",ce,data,0},
+		{"long name",ForType(new(testShape)),long,2}, // Only the error is allocated.
+	} {
+		allocs := testing.AllocsPerRun(100,func() {
+			br.Reset(c.data)
+			Validate(c.ce,preciseio.PreciseReader{R:br})
+		})
+		if allocs>c.max { t.Errorf("%s: %v allocations per run",c.name,allocs) }
+	}
+	
+	// The name is checked against MaxBytes before it is read.
+	r := Limits{MaxBytes:100}.Wrap(reader(long))
+	if e := Validate(ForType(new(testShape)),r); limitErr(e)!="MaxBytes" { t.Errorf("got %v, want MaxBytes",e) }
+}
@@ -0,0 +1,168 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "fmt"
+import "reflect"
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+
+const ourTag = "serializer"
+
+type structField struct{
+	ord uint64
+	sf  reflect.StructField
+}
+
+// Returns the ordinal of the serializer:"N" tag, if any.
+func tagOrdinal(sf reflect.StructField) (uint64,bool) {
+	tag := sf.Tag.Get(ourTag)
+	if j := strings.IndexByte(tag,','); j>=0 { tag = tag[:j] }
+	ord,err := strconv.ParseUint(tag,10,32)
+	if err!=nil || ord==0 { return 0,false }
+	return ord,true
+}
+
+// Lists the serialized fields of a structure, ordered by their ordinal.
+//
+// If any field has a serializer:"N" tag, only the tagged fields are serialized.
+// Otherwise all exported fields are serialized in the order of declaration.
+// Fields tagged with serializer:"-" are always ignored.
+func structFields(t reflect.Type) ([]structField,error) {
+	var tagged,untagged []structField
+	for i,n := 0,t.NumField() ; i<n ; i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get(ourTag)
+		if tag=="-" { continue }
+		if j := strings.IndexByte(tag,','); j>=0 { tag = tag[:j] }
+		if tag=="" {
+			if f.PkgPath!="" { continue } // unexported
+			untagged = append(untagged,structField{uint64(len(untagged)+1),f})
+			continue
+		}
+		ord,err := strconv.ParseUint(tag,10,32)
+		if err!=nil || ord==0 { return nil,fmt.Errorf("Field %s.%s: invalid tag %q",t,f.Name,tag) }
+		if f.PkgPath!="" { return nil,fmt.Errorf("Field %s.%s: unexported field can not be serialized",t,f.Name) }
+		tagged = append(tagged,structField{ord,f})
+	}
+	if len(tagged)==0 { return untagged,nil }
+	sort.SliceStable(tagged,func(i,j int) bool { return tagged[i].ord<tagged[j].ord })
+	for i := 1 ; i<len(tagged) ; i++ {
+		if tagged[i-1].ord==tagged[i].ord {
+			return nil,fmt.Errorf("Field %s.%s: ordinal %d is already used by %s",t,tagged[i].sf.Name,tagged[i].ord,tagged[i-1].sf.Name)
+		}
+	}
+	return tagged,nil
+}
+
+type structKey struct{
+	t reflect.Type
+	versioned bool
+}
+
+// The context of a struct codec construction.
+type structCtx struct{
+	versioned bool
+	
+	// Struct codecs, which are currently being built. Used to resolve cycles.
+	pending map[reflect.Type]*StructBuilder
+}
+
+var structCacheLock sync.RWMutex
+var structCache = make(map[structKey]*StructBuilder)
+
+// Obtains the (inline) StructBuilder for the structure type t.
+// If ctx is nil, a non-versioned codec is returned.
+func structCodec(t reflect.Type, ctx *structCtx) (*StructBuilder,error) {
+	key := structKey{t,ctx!=nil && ctx.versioned}
+	structCacheLock.RLock()
+	sb,ok := structCache[key]
+	structCacheLock.RUnlock()
+	if ok { return sb,nil }
+	if ctx!=nil {
+		if sb,ok = ctx.pending[t]; ok { return sb,nil }
+	}
+	
+	top := ctx==nil || ctx.pending==nil
+	if top { ctx = &structCtx{key.versioned,make(map[reflect.Type]*StructBuilder)} }
+	
+	fields,err := structFields(t)
+	if err!=nil { return nil,err }
+	if key.versioned {
+		for _,f := range fields {
+			if _,ok := tagOrdinal(f.sf); !ok { return nil,fmt.Errorf("Field %s.%s: versioned structures require a serializer:\"N\" tag",t,f.sf.Name) }
+		}
+	}
+	sb = &StructBuilder{t:reflect.PtrTo(t),noptr:true,versioned:key.versioned}
+	ctx.pending[t] = sb
+	for _,f := range fields {
+		ser := serializerForIn(f.sf.Type,ctx)
+		if ser==nil { return nil,fmt.Errorf("Field %s.%s: non-supported type: %v",t,f.sf.Name,f.sf.Type) }
+		sb.addField(f.ord,ser,f.sf.Index)
+	}
+	
+	if top {
+		structCacheLock.Lock()
+		defer structCacheLock.Unlock()
+		for pt,psb := range ctx.pending {
+			pk := structKey{pt,key.versioned}
+			if _,ok := structCache[pk]; !ok { structCache[pk] = psb }
+		}
+	}
+	return sb,nil
+}
+
+// Obtains a serializer for i, which must be a *struct{}.
+// The fields and their order are derived from the struct-tags:
+//
+//	type Foo struct{
+//		Naming  int    `serializer:"1"`
+//		Content string `serializer:"2"`
+//		Nested  Bar    `serializer:"3"` // Bar is handled recursively.
+//		Cache   []byte `serializer:"-"` // Not serialized.
+//	}
+//
+// If no field has a serializer:"N" tag, all exported fields are serialized in
+// the order of declaration. The codecs are built once per type and cached.
+//
+// The returned codec is equivalent to With(i).Field(...)...
+func ForStruct(i interface{}) CodecElement {
+	ti := reflect.TypeOf(i)
+	if ti.Kind()!=reflect.Ptr || ti.Elem().Kind()!=reflect.Struct { panic(fmt.Sprintf("Required *struct{}, but got %v",ti)) }
+	sb,err := structCodec(ti.Elem(),nil)
+	if err!=nil { panic(err.Error()) }
+	return sb.withPtr()
+}
+
+// Like ForStruct, but the codec is equivalent to WithInline(i).Field(...)...
+func ForStructInline(i interface{}) CodecElement {
+	ti := reflect.TypeOf(i)
+	if ti.Kind()!=reflect.Ptr || ti.Elem().Kind()!=reflect.Struct { panic(fmt.Sprintf("Required *struct{}, but got %v",ti)) }
+	sb,err := structCodec(ti.Elem(),nil)
+	if err!=nil { panic(err.Error()) }
+	return sb
+}
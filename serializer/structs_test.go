@@ -0,0 +1,140 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "github.com/byte-mug/golibs/preciseio"
+import "bytes"
+import "reflect"
+import "testing"
+
+func encode(t testing.TB, ce CodecElement, v interface{}) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	w := &preciseio.PreciseWriter{W:buf}
+	w.Initialize()
+	if e := Serialize(ce,w,v); e!=nil { t.Fatalf("Serialize(%#v): %v",v,e) }
+	return buf.Bytes()
+}
+
+func decode(ce CodecElement, data []byte) (interface{},error) {
+	return Deserialize(ce,reader(data))
+}
+
+func reader(data []byte) preciseio.PreciseReader {
+	return preciseio.PreciseReader{R:bytes.NewReader(data)}
+}
+
+func newWriter() *preciseio.PreciseWriter {
+	w := &preciseio.PreciseWriter{W:new(bytes.Buffer)}
+	w.Initialize()
+	return w
+}
+
+func expectPanic(t *testing.T, name string, f func()) {
+	t.Helper()
+	defer func() {
+		if recover()==nil { t.Errorf("%s: did not panic",name) }
+	}()
+	f()
+}
+
+type tagged struct{
+	C  string `serializer:"3"`
+	A  int    `serializer:"1"`
+	X  int
+	B  []byte `serializer:"2"`
+	No int    `serializer:"-"`
+}
+
+type untagged struct{
+	A   int
+	B   string
+	c   int
+	Out int `serializer:"-"`
+}
+
+func TestForStructOrder(t *testing.T) {
+	v := &tagged{C:"c",A:1,X:9,B:[]byte("b"),No:7}
+	manual := With(new(tagged)).Field("A").Field("B").Field("C")
+	if got,want := encode(t,ForStruct(new(tagged)),v),encode(t,manual,v); !bytes.Equal(got,want) {
+		t.Errorf("tagged: got %x, want %x",got,want)
+	}
+	u := &untagged{A:1,B:"b",c:3,Out:4}
+	manual = With(new(untagged)).Field("A").Field("B")
+	if got,want := encode(t,ForStruct(new(untagged)),u),encode(t,manual,u); !bytes.Equal(got,want) {
+		t.Errorf("untagged: got %x, want %x",got,want)
+	}
+	
+	r,e := decode(ForStruct(new(tagged)),encode(t,ForStruct(new(tagged)),v))
+	if e!=nil { t.Fatal(e) }
+	if want := (&tagged{C:"c",A:1,B:[]byte("b")}) ; !reflect.DeepEqual(r,want) { t.Errorf("got %+v, want %+v",r,want) }
+}
+
+func TestForStructInline(t *testing.T) {
+	v := tagged{A:1}
+	inline := encode(t,ForStructInline(new(tagged)),v)
+	ptr := encode(t,ForStruct(new(tagged)),&v)
+	if !bytes.Equal(ptr[1:],inline) || ptr[0]!=0xff { t.Errorf("inline %x, pointer %x",inline,ptr) }
+	if b := encode(t,ForStruct(new(tagged)),(*tagged)(nil)); !bytes.Equal(b,[]byte{0}) { t.Errorf("nil: %x",b) }
+}
+
+func TestForStructInvalid(t *testing.T) {
+	type badTag struct{ A int `serializer:"x"` }
+	type zeroTag struct{ A int `serializer:"0"` }
+	type dupTag struct{ A int `serializer:"1"`; B int `serializer:"1"` }
+	type unexported struct{ a int `serializer:"1"` }
+	type unsupported struct{ F func() }
+	expectPanic(t,"invalid tag",func() { ForStruct(new(badTag)) })
+	expectPanic(t,"zero tag",func() { ForStruct(new(zeroTag)) })
+	expectPanic(t,"duplicate tag",func() { ForStruct(new(dupTag)) })
+	expectPanic(t,"unexported",func() { ForStruct(new(unexported)) })
+	expectPanic(t,"unsupported",func() { ForStruct(new(unsupported)) })
+	expectPanic(t,"non-pointer",func() { ForStruct(tagged{}) })
+}
+
+type treeNode struct{
+	Value    int
+	Children []*treeNode
+	Other    *otherNode
+}
+type otherNode struct{
+	Back *treeNode
+	Name string
+}
+
+func TestForStructCycles(t *testing.T) {
+	v := &treeNode{1,[]*treeNode{{2,nil,nil},{3,nil,&otherNode{&treeNode{Value:4},"x"}}},nil}
+	ce := ForStruct(new(treeNode))
+	r,e := decode(ce,encode(t,ce,v))
+	if e!=nil { t.Fatal(e) }
+	if !reflect.DeepEqual(r,v) { t.Errorf("got %+v, want %+v",r,v) }
+	
+	// The types of the cycle are cached as well.
+	o := &otherNode{v,"y"}
+	ce = ForStruct(new(otherNode))
+	r,e = decode(ce,encode(t,ce,o))
+	if e!=nil { t.Fatal(e) }
+	if !reflect.DeepEqual(r,o) { t.Errorf("got %+v, want %+v",r,o) }
+}
@@ -0,0 +1,121 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "bytes"
+import "fmt"
+import "reflect"
+import "github.com/byte-mug/golibs/preciseio"
+
+/*
+Versioned structures are encoded as a sequence of (field-ID, length, data) triples,
+terminated by a field-ID of 0:
+
+	uvarint(id) blob(field) ... uvarint(id) blob(field) uvarint(0)
+
+This way, a reader can skip fields it does not know, and fields missing in the
+input retain their default value. The field-ID is taken from the serializer:"N" tag.
+*/
+
+// If a structure, that is decoded by a versioned codec, implements this interface,
+// fields that are missing in the input are set to the values SetDefaults() assigns
+// to a new structure. Otherwise, they are set to their zero value.
+type Defaulter interface{
+	SetDefaults()
+}
+
+// Switches the StructBuilder to the versioned encoding.
+//
+// The field-ID of each field is taken from its serializer:"N" tag. Every field must
+// be tagged, otherwise Versioned() panics: IDs derived from the order of the fields
+// would silently change, if fields were reordered. The IDs must be unique.
+func (s *StructBuilder) Versioned() *StructBuilder {
+	if s.untagged!="" { panic(fmt.Sprintf("Field %s: versioned structures require a serializer:\"N\" tag",s.untagged)) }
+	if s.versioned { return s }
+	s.versioned = true
+	for i := range s.fields { s.indexField(i) }
+	return s
+}
+
+// Like ForStruct, but uses the versioned encoding (recursively for nested structures).
+// Every serialized field needs a serializer:"N" tag. Fields can be added and removed
+// as long as their tags are not reused.
+func ForStructVersioned(i interface{}) CodecElement {
+	ti := reflect.TypeOf(i)
+	if ti.Kind()!=reflect.Ptr || ti.Elem().Kind()!=reflect.Struct { panic(fmt.Sprintf("Required *struct{}, but got %v",ti)) }
+	sb,err := structCodec(ti.Elem(),&structCtx{versioned:true})
+	if err!=nil { panic(err.Error()) }
+	return sb.withPtr()
+}
+
+func (s *StructBuilder) writeVersioned(w *preciseio.PreciseWriter,ev reflect.Value) error {
+	buf := new(bytes.Buffer)
+	fw := preciseio.PreciseWriterFromPool()
+	defer fw.PutToPool()
+	fw.W = buf
+	for _,field := range s.fields {
+		buf.Reset()
+		e := field.ce.Write(fw,ev.FieldByIndex(field.idxs))
+		if e!=nil { return e }
+		e = w.WriteUvarint(field.id)
+		if e!=nil { return e }
+		e = w.WriteBlob(buf.Bytes())
+		if e!=nil { return e }
+	}
+	return w.WriteUvarint(0)
+}
+func (s *StructBuilder) readVersioned(r preciseio.PreciseReader,ev reflect.Value) error {
+	// The fields are decoded in place (see DeserializeInto), so only the missing ones are reset.
+	var seen uint64
+	var seenMore []bool
+	if len(s.fields)>64 { seenMore = make([]bool,len(s.fields)) }
+	for {
+		id,e := r.ReadUvarint()
+		if e!=nil { return e }
+		if id==0 { break }
+		blob,e := readBlob(r)
+		if e!=nil { return e }
+		i,ok := s.byID[id]
+		if !ok { continue } // Unknown field: skip it.
+		if seenMore!=nil { seenMore[i] = true } else { seen |= 1<<uint(i) }
+		field := s.fields[i]
+		e = readEmbedded(field.ce,r,blob,ev.FieldByIndex(field.idxs))
+		if e!=nil { return e }
+	}
+	var def reflect.Value
+	for i,field := range s.fields {
+		if seenMore!=nil && seenMore[i] || seenMore==nil && seen&(1<<uint(i))!=0 { continue }
+		if !def.IsValid() { def = defaults(ev.Type()) }
+		ev.FieldByIndex(field.idxs).Set(def.FieldByIndex(field.idxs))
+	}
+	return nil
+}
+
+// Returns a new structure of type t with its defaults set.
+func defaults(t reflect.Type) reflect.Value {
+	pv := reflect.New(t)
+	if d,ok := pv.Interface().(Defaulter); ok { d.SetDefaults() }
+	return pv.Elem()
+}
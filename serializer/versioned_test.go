@@ -0,0 +1,109 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package serializer
+
+import "github.com/byte-mug/golibs/preciseio"
+import "bytes"
+import "reflect"
+import "strings"
+import "testing"
+
+type recordV1 struct{
+	ID   int    `serializer:"1"`
+	Name string `serializer:"2"`
+}
+type recordV2 struct{
+	ID    int      `serializer:"1"`
+	Email string   `serializer:"3"` // Name was removed, Email and Tags added.
+	Tags  []string `serializer:"4"`
+	Data  []byte   `serializer:"5"`
+	Cache int      `serializer:"-"`
+}
+type recordV2Defaults struct{
+	ID    int    `serializer:"1"`
+	Email string `serializer:"3"`
+}
+func (r *recordV2Defaults) SetDefaults() { r.Email = "none" }
+
+func TestVersionedCompatibility(t *testing.T) {
+	v1 := ForStructVersioned(new(recordV1))
+	v2 := ForStructVersioned(new(recordV2))
+	
+	// old -> new: Email and Tags are missing, Name is skipped.
+	r,e := decode(v2,encode(t,v1,&recordV1{7,"seven"}))
+	if e!=nil { t.Fatal(e) }
+	if want := (&recordV2{ID:7}) ; !reflect.DeepEqual(r,want) { t.Errorf("old->new: got %+v, want %+v",r,want) }
+	
+	// new -> old: Email and Tags are skipped, Name is missing.
+	r,e = decode(v1,encode(t,v2,&recordV2{ID:8,Email:"e",Tags:[]string{"a"}}))
+	if e!=nil { t.Fatal(e) }
+	if want := (&recordV1{ID:8}) ; !reflect.DeepEqual(r,want) { t.Errorf("new->old: got %+v, want %+v",r,want) }
+	
+	r,e = decode(ForStructVersioned(new(recordV2Defaults)),encode(t,v1,&recordV1{9,"nine"}))
+	if e!=nil { t.Fatal(e) }
+	if want := (&recordV2Defaults{9,"none"}) ; !reflect.DeepEqual(r,want) { t.Errorf("defaults: got %+v, want %+v",r,want) }
+}
+
+func TestVersionedRequiresTags(t *testing.T) {
+	expectPanic(t,"ForStructVersioned",func() { ForStructVersioned(new(untagged)) })
+	expectPanic(t,"Versioned()",func() { With(new(untagged)).Field("A").Versioned() })
+	expectPanic(t,"Field() after Versioned()",func() { With(new(tagged)).Field("A").Versioned().Field("X") })
+	expectPanic(t,"duplicate ID",func() { With(new(tagged)).Field("A").Field("A").Versioned() })
+	With(new(tagged)).Field("A").Field("C").Versioned()
+}
+
+func TestPlainBuilderIgnoresIDs(t *testing.T) {
+	// Plain builders are positional: Tags and repeated fields don't matter, as before.
+	ce := With(new(tagged)).Field("X").Field("A").Field("C").Field("A")
+	r,e := decode(ce,encode(t,ce,&tagged{C:"c",A:1,X:2}))
+	if want := (&tagged{C:"c",A:1,X:2}) ; e!=nil || !reflect.DeepEqual(r,want) { t.Errorf("got %+v, %v",r,e) }
+	
+	// X has no tag, A has tag 1: No ID conflict, as IDs are not assigned.
+	ce = With(new(tagged)).Field("X").Field("A")
+	if data := encode(t,ce,&tagged{A:1,X:2}) ; !reflect.DeepEqual(data,[]byte{0xff,4,2}) { t.Errorf("encoded %x",data) }
+}
+
+func TestVersionedLeftoverBytes(t *testing.T) {
+	v1 := ForStructVersioned(new(recordV1))
+	// Field 1 holds varint(1) and a stray byte.
+	data := []byte{0xff, 1,2,0x02,0x00, 0}
+	if _,e := decode(v1,data); e==nil || !strings.Contains(e.Error(),"left over") { t.Errorf("got %v",e) }
+	if e := Validate(v1,preciseio.PreciseReader{R:bytes.NewReader(data)}); e==nil || !strings.Contains(e.Error(),"left over") { t.Errorf("Validate: got %v",e) }
+	data = []byte{0xff, 1,1,0x02, 0}
+	if r,e := decode(v1,data); e!=nil || r.(*recordV1).ID!=1 { t.Errorf("got %+v, %v",r,e) }
+}
+
+func TestVersionedInto(t *testing.T) {
+	v2 := ForStructVersioned(new(recordV2))
+	data := encode(t,v2,&recordV2{ID:1,Data:[]byte("abc")})
+	
+	buf := make([]byte,0,16)
+	dst := &recordV2{ID:5,Email:"old",Tags:[]string{"x"},Data:buf,Cache:42}
+	if e := DeserializeInto(v2,preciseio.PreciseReader{R:bytes.NewReader(data)},dst); e!=nil { t.Fatal(e) }
+	if len(dst.Tags)!=0 { t.Errorf("Tags = %v",dst.Tags) }
+	dst.Tags = nil // Decoded into the existing capacity, so it is empty rather than nil.
+	if want := (&recordV2{ID:1,Data:[]byte("abc"),Cache:42}) ; !reflect.DeepEqual(dst,want) { t.Errorf("got %+v, want %+v",dst,want) }
+	if &dst.Data[0]!=&buf[:1][0] { t.Error("Data was not decoded into the existing buffer") }
+}